@@ -3,28 +3,74 @@ package ldapsync
 import (
 	"crypto/tls"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/kolo/xmlrpc"
 )
 
 // UyuniCaller object
 type UyuniCaller struct {
-	client   *xmlrpc.Client
-	user     string
-	password string
-	session  string
+	url          string
+	skipSslCheck bool
+	clients      chan *xmlrpc.Client
+	throttle     *time.Ticker
+	user         string
+	password     string
+	session      string
+	sessionLock  sync.Mutex
 }
 
 // NewUyuniCaller is a constructor for the UyuniCaller object
 func NewUyuniCaller(url string, skipSslCheck bool) *UyuniCaller {
 	uc := new(UyuniCaller)
-	uc.client, _ = xmlrpc.NewClient(url,
+	uc.url = url
+	uc.skipSslCheck = skipSslCheck
+
+	return uc.SetConcurrency(1)
+}
+
+// Create a new XML-RPC client
+func (c *UyuniCaller) newClient() *xmlrpc.Client {
+	client, _ := xmlrpc.NewClient(c.url,
 		&http.Transport{
 			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: skipSslCheck,
+				InsecureSkipVerify: c.skipSslCheck,
 			},
 		})
-	return uc
+	return client
+}
+
+// SetConcurrency sets how many calls can be performed at the same time.
+// XML-RPC client serialises its requests, so each concurrent call gets its own client.
+func (c *UyuniCaller) SetConcurrency(concurrency int) *UyuniCaller {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	c.clients = make(chan *xmlrpc.Client, concurrency)
+	for i := 0; i < concurrency; i++ {
+		c.clients <- c.newClient()
+	}
+	return c
+}
+
+// SetRateLimit sets maximum amount of calls per second. Zero means unlimited.
+func (c *UyuniCaller) SetRateLimit(perSecond int) *UyuniCaller {
+	if c.throttle != nil {
+		c.throttle.Stop()
+		c.throttle = nil
+	}
+
+	if perSecond > 0 {
+		// The ticker cannot go faster than once per nanosecond
+		interval := time.Second / time.Duration(perSecond)
+		if interval <= 0 {
+			interval = 1
+		}
+		c.throttle = time.NewTicker(interval)
+	}
+	return c
 }
 
 // SetUser sets the username for the authentication
@@ -56,6 +102,9 @@ func (c *UyuniCaller) authenticate() {
 
 // Session returns a token after the authentication
 func (c *UyuniCaller) Session() string {
+	c.sessionLock.Lock()
+	defer c.sessionLock.Unlock()
+
 	if c.session == "" {
 		c.authenticate()
 	}
@@ -64,7 +113,14 @@ func (c *UyuniCaller) Session() string {
 
 // Call any XML-RPC function
 func (c *UyuniCaller) Call(name string, args ...interface{}) (interface{}, error) {
+	if c.throttle != nil {
+		<-c.throttle.C
+	}
+
+	client := <-c.clients
+	defer func() { c.clients <- client }()
+
 	var res interface{}
-	err := c.client.Call(name, args, &res)
+	err := client.Call(name, args, &res)
 	return res, err
 }
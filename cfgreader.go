@@ -6,34 +6,105 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/go-ldap/ldap"
 	"github.com/go-yaml/yaml"
+	"github.com/thoas/go-funk"
 )
 
+// Identity sources of the directory
+const (
+	SourceLDAP = "ldap"
+	SourceLDIF = "ldif"
+	SourceSCIM = "scim"
+	SourceCSV  = "csv"
+	SourceYAML = "yaml"
+)
+
+// Search scopes of the user bases
+const (
+	ScopeSub  = "sub"
+	ScopeOne  = "one"
+	ScopeBase = "base"
+)
+
+// DefaultUserFilter matches entries of the users, if the user base has no own filter
+const DefaultUserFilter = "(|(objectClass=organizationalPerson)(objectClass=inetOrgPerson))"
+
+// UserBase is a DN to search users under, with its own filter and scope
+type UserBase struct {
+	Dn     string
+	Filter string
+	Scope  string
+}
+
+// Case folding of the Uyuni logins
+const (
+	LogincasePreserve = "preserve"
+	LogincaseLower    = "lower"
+)
+
+// AttrmapDefault is the key of the attribute map, applied to all users
+const AttrmapDefault = "default"
+
 // Config object
 type Config struct {
 	Common struct {
-		Configpath string
-		Logpath    string
+		Configpath    string
+		Logpath       string
+		Statepath     string
+		Overridespath string
 	}
 
 	Directory struct {
-		User     string
-		Password string
-		Host     string
-		Port     int64
+		User      string
+		Password  string
+		Host      string
+		Port      int64
+		Srvdomain string
+
+		Source     string
+		Sourcepath string
+
+		Groupsbase string
+
+		Groups    map[string][]string
+		Roles     map[string][]string
+		Attrmap   map[string]map[string]string
+		Frozen    []string
+		Allusers  string
+		Userbases []*UserBase
 
-		Groups   map[string][]string
-		Roles    map[string][]string
-		Attrmap  map[string]map[string]string
-		Frozen   []string
-		Allusers string
+		Names struct {
+			Firstname string
+			Lastname  string
+		}
+		Transforms map[string][]string
+		Logincase  string
+		Collisions string
+
+		Idattributes []string
+		Frozengroups []string
+		Frozenroles  []string
+
+		Deny       map[string][]string
+		Precedence []string
+		Temporary  []*TemporaryMapping
+	}
+
+	Scim struct {
+		Listen   string
+		Token    string
+		Certfile string
+		Keyfile  string
 	}
 
 	Spacewalk struct {
-		Url      string
-		User     string
-		Password string
-		Checkssl bool
+		Url       string
+		User      string
+		Password  string
+		Checkssl  bool
+		Workers   int
+		Ratelimit int
 	}
 }
 
@@ -43,6 +114,8 @@ func NewConfig() *Config {
 	cfg.Directory.Groups = make(map[string][]string)
 	cfg.Directory.Roles = make(map[string][]string)
 	cfg.Directory.Attrmap = make(map[string]map[string]string)
+	cfg.Directory.Transforms = make(map[string][]string)
+	cfg.Directory.Deny = make(map[string][]string)
 
 	return cfg
 }
@@ -92,9 +165,72 @@ func (cfg *ConfigReader) setDefaults() {
 		cfg.config.Common.Logpath = "/var/log/rhn/ldapsync.log"
 	}
 
+	if cfg.Config().Common.Statepath == "" {
+		cfg.config.Common.Statepath = "/var/lib/rhn/ldapsync/state.yaml"
+	}
+
+	if cfg.Config().Common.Overridespath == "" {
+		cfg.config.Common.Overridespath = "/etc/rhn/ldapsync-overrides.conf"
+	}
+
+	if cfg.Config().Directory.Source == "" {
+		cfg.config.Directory.Source = SourceLDAP
+	}
+
+	// Static sources have no immutable IDs
+	if len(cfg.Config().Directory.Idattributes) == 0 &&
+		(cfg.Config().Directory.Source == SourceLDAP || cfg.Config().Directory.Source == SourceSCIM) {
+		cfg.config.Directory.Idattributes = DefaultIdattributes
+	}
+
+	if cfg.Config().Directory.Allusers == "" && len(cfg.Config().Directory.Userbases) > 0 {
+		cfg.config.Directory.Allusers = cfg.Config().Directory.Userbases[0].Dn
+	}
+
+	if len(cfg.Config().Directory.Userbases) == 0 && cfg.Config().Directory.Allusers != "" {
+		cfg.config.Directory.Userbases = []*UserBase{{Dn: cfg.Config().Directory.Allusers}}
+	}
+
+	for _, base := range cfg.Config().Directory.Userbases {
+		if base.Filter == "" {
+			base.Filter = DefaultUserFilter
+		}
+		if base.Scope == "" {
+			base.Scope = ScopeSub
+		}
+	}
+
+	if cfg.Config().Directory.Names.Firstname == "" {
+		cfg.config.Directory.Names.Firstname = DefaultFirstnameTemplate
+	}
+
+	if cfg.Config().Directory.Names.Lastname == "" {
+		cfg.config.Directory.Names.Lastname = DefaultLastnameTemplate
+	}
+
+	if cfg.Config().Directory.Logincase == "" {
+		cfg.config.Directory.Logincase = LogincasePreserve
+	}
+
+	if cfg.Config().Directory.Collisions == "" {
+		cfg.config.Directory.Collisions = CollisionSkip
+	}
+
+	if cfg.Config().Directory.Groupsbase == "" && cfg.Config().Directory.Allusers != "" {
+		cfg.config.Directory.Groupsbase = "ou=Groups," + ParentDN(cfg.Config().Directory.Allusers)
+	}
+
+	if cfg.Config().Scim.Listen == "" {
+		cfg.config.Scim.Listen = ":8080"
+	}
+
 	if cfg.Config().Directory.Port == 0 {
 		cfg.config.Directory.Port = 389
 	}
+
+	if cfg.Config().Spacewalk.Workers == 0 {
+		cfg.config.Spacewalk.Workers = 1
+	}
 }
 
 func (cfg *ConfigReader) validateAggregate(aggr map[string][]string) error {
@@ -102,9 +238,15 @@ func (cfg *ConfigReader) validateAggregate(aggr map[string][]string) error {
 		return errors.New("Block is empty")
 	}
 
-	for dn := range aggr {
+	// Empty list of roles is valid: members are synchronised without any role
+	for dn, roles := range aggr {
 		if len(dn) == 0 {
-			return fmt.Errorf("DN '%s' contains no mapped roles", dn)
+			return fmt.Errorf("Empty DN is mapped to roles %v", roles)
+		}
+		for _, role := range roles {
+			if !IsPossibleRole(role) {
+				return fmt.Errorf("DN '%s' has unknown Uyuni role '%s'", dn, role)
+			}
 		}
 	}
 
@@ -115,10 +257,7 @@ func (cfg *ConfigReader) validateAggregate(aggr map[string][]string) error {
 func (cfg *ConfigReader) validate() *ConfigReader {
 	for errmsg, attr := range map[string]interface{}{
 		// Directory
-		"DN for LDAP user is not specified":                            cfg.config.Directory.User,
-		"Password for LDAP user is not specified":                      cfg.config.Directory.Password,
-		"Fully qualified domain name for LDAP server is not specified": cfg.config.Directory.Host,
-		"DN for all LDAP users is not specified":                       cfg.config.Directory.Allusers,
+		"DN for all LDAP users is not specified": cfg.config.Directory.Allusers,
 
 		// Uyuni
 		"Uyuni RPC-API URL is not specified":               cfg.config.Spacewalk.Url,
@@ -129,8 +268,109 @@ func (cfg *ConfigReader) validate() *ConfigReader {
 		}
 	}
 
+	switch cfg.config.Directory.Source {
+	case SourceLDAP:
+		if cfg.config.Directory.User == "" {
+			Log.Fatal("DN for LDAP user is not specified")
+		}
+		if cfg.config.Directory.Password == "" {
+			Log.Fatal("Password for LDAP user is not specified")
+		}
+		if cfg.config.Directory.Host == "" && cfg.config.Directory.Srvdomain == "" {
+			Log.Fatal("Either fully qualified domain name for LDAP server or the domain for SRV discovery needs to be specified")
+		}
+	case SourceLDIF, SourceCSV, SourceYAML:
+		if cfg.config.Directory.Sourcepath == "" {
+			Log.Fatalf("Path to the %s source is not specified", cfg.config.Directory.Source)
+		}
+	case SourceSCIM:
+		if cfg.config.Scim.Token == "" {
+			Log.Fatal("Bearer token for the SCIM endpoint is not specified")
+		}
+	default:
+		Log.Fatalf("Unknown directory source: %s", cfg.config.Directory.Source)
+	}
+
+	for _, base := range cfg.config.Directory.Userbases {
+		if base.Dn == "" {
+			Log.Fatal("DN of the user base is not specified")
+		}
+		if _, err := ldap.CompileFilter(base.Filter); err != nil {
+			Log.Fatalf("Invalid filter '%s' of the user base '%s': %s", base.Filter, base.Dn, err.Error())
+		}
+		if !funk.ContainsString([]string{ScopeSub, ScopeOne, ScopeBase}, base.Scope) {
+			Log.Fatalf("Unknown scope '%s' of the user base '%s'", base.Scope, base.Dn)
+		}
+	}
+
+	for _, tpl := range []string{cfg.config.Directory.Names.Firstname, cfg.config.Directory.Names.Lastname} {
+		if _, err := ParseNameTemplate(tpl); err != nil {
+			Log.Fatal(err)
+		}
+	}
+
+	for field, specs := range cfg.config.Directory.Transforms {
+		if !funk.ContainsString([]string{FieldUid, FieldEmail, FieldFirstname, FieldLastname}, field) {
+			Log.Fatalf("Transforms are not supported for the field '%s'", field)
+		}
+		if _, err := ParseTransformChain(specs); err != nil {
+			Log.Fatalf("Invalid transforms of the field '%s': %s", field, err.Error())
+		}
+	}
+
+	if !funk.ContainsString([]string{LogincasePreserve, LogincaseLower}, cfg.config.Directory.Logincase) {
+		Log.Fatalf("Unknown login case folding: %s", cfg.config.Directory.Logincase)
+	}
+
+	if !funk.ContainsString([]string{CollisionSkip, CollisionPrefer, CollisionFail}, cfg.config.Directory.Collisions) {
+		Log.Fatalf("Unknown policy of login collisions: %s", cfg.config.Directory.Collisions)
+	}
+
+	if cfg.config.Spacewalk.Workers < 0 || cfg.config.Spacewalk.Ratelimit < 0 {
+		Log.Fatal("Amount of Uyuni workers and the rate limit cannot be negative")
+	}
+
+	for _, entry := range cfg.config.Directory.Frozen {
+		if err := ValidateFrozenEntry(entry); err != nil {
+			Log.Fatal(err)
+		}
+	}
+
+	if len(cfg.config.Directory.Deny) > 0 {
+		if err := cfg.validateAggregate(cfg.config.Directory.Deny); err != nil {
+			Log.Fatal(err)
+		}
+	}
+
+	for _, pdn := range cfg.config.Directory.Precedence {
+		if !cfg.isMappedDN(pdn) {
+			Log.Fatalf("DN '%s' in precedence is not mapped in groups, roles, deny or temporary", pdn)
+		}
+	}
+
+	for _, tm := range cfg.config.Directory.Temporary {
+		if tm.Dn == "" || len(tm.Roles) == 0 {
+			Log.Fatal("Temporary mapping needs a DN and at least one role")
+		}
+		for _, role := range tm.Roles {
+			if !IsPossibleRole(role) {
+				Log.Fatalf("DN '%s' has unknown Uyuni role '%s' in temporary mappings", tm.Dn, role)
+			}
+		}
+		if tm.ValidFrom != nil && tm.ValidUntil != nil && !tm.ValidFrom.Before(*tm.ValidUntil) {
+			Log.Fatalf("Temporary mapping of '%s' ends before it starts", tm.Dn)
+		}
+	}
+
+	for _, role := range cfg.config.Directory.Frozenroles {
+		if !IsPossibleRole(role) {
+			Log.Fatalf("Unknown frozen Uyuni role '%s'", role)
+		}
+	}
+
 	// Look if at least one frozen dude has this role
-	if len(cfg.config.Directory.Frozen) == 0 {
+	if len(cfg.config.Directory.Frozen) == 0 && len(cfg.config.Directory.Frozengroups) == 0 &&
+		len(cfg.config.Directory.Frozenroles) == 0 {
 		Log.Fatal("You have to regiser at least one frozen account with Organisation Manager role for emergency purposes")
 	}
 
@@ -140,6 +380,10 @@ func (cfg *ConfigReader) validate() *ConfigReader {
 	}
 
 	for _, aggr := range []map[string][]string{cfg.config.Directory.Groups, cfg.config.Directory.Roles} {
+		// Only one of them is mandatory
+		if len(aggr) == 0 {
+			continue
+		}
 		err := cfg.validateAggregate(aggr)
 		if err != nil {
 			Log.Fatal(err)
@@ -149,6 +393,24 @@ func (cfg *ConfigReader) validate() *ConfigReader {
 	return cfg
 }
 
+// Returns true if the DN is a key of groups, roles or deny, or a DN of the temporary mappings
+func (cfg *ConfigReader) isMappedDN(dn string) bool {
+	for _, aggr := range []map[string][]string{cfg.config.Directory.Groups, cfg.config.Directory.Roles, cfg.config.Directory.Deny} {
+		for mdn := range aggr {
+			if EqualDN(mdn, dn) {
+				return true
+			}
+		}
+	}
+	for _, tm := range cfg.config.Directory.Temporary {
+		if EqualDN(tm.Dn, dn) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Config returns the configuration object
 func (cfg *ConfigReader) Config() *Config {
 	return cfg.config
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	ldapsync "github.com/isbm/uyuni-ldap-sync"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatCSV  = "csv"
+)
+
+// Render the data as JSON or YAML
+func renderStructured(out io.Writer, format string, data interface{}) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(data)
+	case FormatYAML:
+		buff, err := yaml.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(buff)
+		return err
+	}
+
+	return fmt.Errorf("Unsupported output format: %s", format)
+}
+
+// Render rows as CSV
+func renderCSV(out io.Writer, header []string, rows [][]string) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// RenderReport renders the sync report in the given format
+func RenderReport(out io.Writer, format string, report *ldapsync.SyncReport) error {
+	if format == FormatCSV {
+		rows := make([][]string, 0)
+		for _, op := range report.Operations {
+			rows = append(rows, []string{op.Uid, op.Action, op.Error})
+		}
+		return renderCSV(out, []string{"uid", "action", "error"}, rows)
+	} else if format != FormatText {
+		return renderStructured(out, format, report)
+	}
+
+	if len(report.Operations) > 0 {
+		fmt.Fprintln(out, "Operations:")
+		for idx, op := range report.Operations {
+			idx++
+			result := "OK"
+			if op.Failed() {
+				result = "FAILED: " + op.Error
+			}
+			fmt.Fprintf(out, "  %d. %s %s - %s\n", idx, op.Action, op.Uid, result)
+		}
+		fmt.Fprintln(out)
+	}
+	printOverrides(out, report.Overrides)
+	fmt.Fprintf(out, "Result: %s (%d operations, %d failed)\n",
+		strings.ToLower(report.Status), len(report.Operations), len(report.Failed()))
+
+	return nil
+}
+
+// Describe changed fields and roles of the user as "before → after" values
+func describeChanges(user *ldapsync.UserOverview) []string {
+	changes := make([]string, 0)
+	for _, change := range user.Changes {
+		changes = append(changes, fmt.Sprintf("%s: %s → %s", change.Field, change.Before, change.After))
+	}
+
+	roles := make([]string, 0)
+	for _, role := range user.RolesAdded {
+		roles = append(roles, "+"+role)
+	}
+	for _, role := range user.RolesRemoved {
+		roles = append(roles, "-"+role)
+	}
+	if len(roles) > 0 {
+		changes = append(changes, "roles: "+strings.Join(roles, " "))
+	}
+
+	for _, denial := range user.Denials {
+		if denial.Effective {
+			changes = append(changes, fmt.Sprintf("denied: %s by %s", denial.Role, denial.Mapping))
+		} else if denial.OverriddenBy != "" {
+			changes = append(changes, fmt.Sprintf("not denied: %s by %s, overridden by %s", denial.Role, denial.Mapping, denial.OverriddenBy))
+		}
+	}
+
+	if user.Override != nil {
+		changes = append(changes, "overridden locally: "+describeOverride(user.Override))
+	}
+
+	return changes
+}
+
+// Describe the local override as its changes, reason and expiration
+func describeOverride(override *ldapsync.AppliedOverride) string {
+	details := make([]string, 0)
+	if override.Expired {
+		details = append(details, "expired, not applied")
+	} else {
+		for _, change := range override.Changes {
+			details = append(details, fmt.Sprintf("%s=%s", change.Field, change.After))
+		}
+		for _, role := range override.RolesAdded {
+			details = append(details, "+"+role)
+		}
+		for _, role := range override.RolesRemoved {
+			details = append(details, "-"+role)
+		}
+		for _, role := range override.RolesKept {
+			details = append(details, fmt.Sprintf("-%s (implied by org_admin, kept)", role))
+		}
+		if override.ValidUntil != nil {
+			details = append(details, "until "+override.ValidUntil.Local().Format("2006-01-02 15:04"))
+		}
+	}
+	if override.Reason != "" {
+		details = append(details, fmt.Sprintf("reason: %s", override.Reason))
+	}
+
+	return strings.Join(details, ", ")
+}
+
+// Print the local overrides in a human readable list
+func printOverrides(out io.Writer, overrides []*ldapsync.AppliedOverride) {
+	if len(overrides) > 0 {
+		fmt.Fprintln(out, "Overridden users:")
+		for idx, override := range overrides {
+			idx++
+			fmt.Fprintf(out, "  %d. %s (%s)\n", idx, override.Uid, describeOverride(override))
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+// Print users of the overview in a human readable list
+func printUsers(out io.Writer, title string, users []*ldapsync.UserOverview) {
+	if len(users) > 0 {
+		fmt.Fprintf(out, "%s:\n", title)
+		for idx, user := range users {
+			idx++
+			fmt.Fprintf(out, "  %d. %s (%s %s) at %s\n", idx, user.Uid, user.Name, user.Secondname, user.Email)
+			for _, change := range describeChanges(user) {
+				fmt.Fprintf(out, "       %s\n", change)
+			}
+		}
+		fmt.Fprintln(out)
+	} else {
+		fmt.Fprintf(out, "No %s has been found for this criteria\n", strings.ToLower(title))
+	}
+}
+
+// RenderOverview renders the overview in the given format
+func RenderOverview(out io.Writer, format string, overview *ldapsync.Overview) error {
+	switch format {
+	case FormatText:
+		fmt.Fprintln(out, "Ignored users:")
+		for idx, uid := range overview.Frozen {
+			idx++
+			fmt.Fprintf(out, "  %d. %s\n", idx, uid)
+		}
+		fmt.Fprintln(out)
+
+		printUsers(out, "New users", overview.UsersByStatus(ldapsync.StatusNew))
+		printUsers(out, "Outdated users", overview.UsersByStatus(ldapsync.StatusOutdated))
+		printUsers(out, "Removed users", overview.UsersByStatus(ldapsync.StatusRemoved))
+
+		if len(overview.Expirations) > 0 {
+			fmt.Fprintln(out, "Expiring roles:")
+			for idx, expiration := range overview.Expirations {
+				idx++
+				fmt.Fprintf(out, "  %d. %s loses %s at %s (%s)\n", idx, expiration.Uid, expiration.Role,
+					expiration.ValidUntil.Local().Format("2006-01-02 15:04"), expiration.Mapping)
+			}
+			fmt.Fprintln(out)
+		}
+
+		printOverrides(out, overview.Overrides)
+
+		if len(overview.Unmanaged) > 0 {
+			fmt.Fprintln(out, "Unmanaged users, left untouched (see \"adopt\" command):")
+			for idx, user := range overview.Unmanaged {
+				idx++
+				fmt.Fprintf(out, "  %d. %s would be %s\n", idx, user.Uid, user.Status)
+			}
+			fmt.Fprintln(out)
+		}
+
+		if len(overview.Renames) > 0 {
+			fmt.Fprintln(out, "Renamed users:")
+			for idx, rename := range overview.Renames {
+				idx++
+				fmt.Fprintf(out, "  %d. %s, renamed to %s in the directory\n", idx, rename.Login, rename.DirectoryUid)
+			}
+			fmt.Fprintln(out)
+		}
+
+		if len(overview.Collisions) > 0 {
+			fmt.Fprintln(out, "Login collisions:")
+			for idx, collision := range overview.Collisions {
+				idx++
+				chosen := collision.Chosen
+				if chosen == "" {
+					chosen = "none, skipped"
+				}
+				fmt.Fprintf(out, "  %d. %s, taken: %s\n", idx, collision.Uid, chosen)
+				for _, dn := range collision.Dns {
+					fmt.Fprintf(out, "     - %s\n", dn)
+				}
+			}
+			fmt.Fprintln(out)
+		}
+	case FormatCSV:
+		rows := make([][]string, 0)
+		for _, user := range overview.Users {
+			rows = append(rows, []string{user.Status, user.Uid, user.Dn, user.Name, user.Secondname, user.Email,
+				strings.Join(user.Roles, " "), strconv.FormatBool(user.AccountChanged), strconv.FormatBool(user.RolesChanged),
+				strings.Join(describeChanges(user), "; ")})
+		}
+		return renderCSV(out, []string{"status", "uid", "dn", "name", "secondname", "email",
+			"roles", "account_changed", "roles_changed", "changes"}, rows)
+	default:
+		return renderStructured(out, format, overview)
+	}
+
+	return nil
+}
+
+// Format a boolean as yes/no
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// RenderExplanation renders the explanation of the user roles in the given format
+func RenderExplanation(out io.Writer, format string, explanation *ldapsync.Explanation) error {
+	if format != FormatText {
+		return renderStructured(out, format, explanation)
+	}
+
+	fmt.Fprintf(out, "User: %s\n", explanation.Uid)
+	fmt.Fprintf(out, "  DN: %s\n", explanation.Dn)
+	fmt.Fprintf(out, "  In LDAP: %s\n", yesNo(explanation.InLDAP))
+	fmt.Fprintf(out, "  In Uyuni: %s\n", yesNo(explanation.InUyuni))
+	fmt.Fprintf(out, "  Frozen: %s\n", yesNo(explanation.Frozen))
+	fmt.Fprintln(out)
+
+	if len(explanation.Roles) > 0 {
+		fmt.Fprintln(out, "Roles:")
+		for _, role := range explanation.Roles {
+			fmt.Fprintf(out, "  %s\n", role.Role)
+			if role.ImpliedBy != "" {
+				fmt.Fprintf(out, "    - implied by %s\n", role.ImpliedBy)
+			}
+			for _, grant := range role.Grants {
+				fmt.Fprintf(out, "    - %s: %s", grant.Source, strings.Join(grant.Path, " → "))
+				if grant.ValidUntil != nil {
+					fmt.Fprintf(out, " (until %s)", grant.ValidUntil.Local().Format("2006-01-02 15:04"))
+				}
+				fmt.Fprintln(out)
+			}
+		}
+	} else {
+		fmt.Fprintln(out, "No roles are mapped to this user")
+	}
+	fmt.Fprintln(out)
+
+	if len(explanation.Denials) > 0 {
+		fmt.Fprintln(out, "Denials:")
+		for _, denial := range explanation.Denials {
+			result := "effective"
+			if !denial.Effective {
+				result = "overridden by " + denial.OverriddenBy
+				if denial.OverriddenBy == "" {
+					result = "role not granted"
+				}
+			}
+			fmt.Fprintf(out, "  %s (%s)\n", denial.Role, result)
+			fmt.Fprintf(out, "    - %s: %s\n", denial.Source, strings.Join(denial.Path, " → "))
+		}
+		fmt.Fprintln(out)
+	}
+
+	if explanation.Override != nil {
+		fmt.Fprintf(out, "Override: %s\n", describeOverride(explanation.Override))
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintf(out, "Next sync: %s\n", explanation.Action)
+	if explanation.Pending != nil {
+		for _, change := range describeChanges(explanation.Pending) {
+			fmt.Fprintf(out, "  %s\n", change)
+		}
+	}
+
+	return nil
+}
+
+// RenderAdopted renders the adopted logins in the given format
+func RenderAdopted(out io.Writer, format string, adopted []string) error {
+	if format != FormatText {
+		return renderStructured(out, format, map[string][]string{"adopted": adopted})
+	}
+
+	if len(adopted) == 0 {
+		fmt.Fprintln(out, "No users has been adopted")
+		return nil
+	}
+
+	fmt.Fprintln(out, "Adopted users:")
+	for idx, uid := range adopted {
+		idx++
+		fmt.Fprintf(out, "  %d. %s\n", idx, uid)
+	}
+
+	return nil
+}
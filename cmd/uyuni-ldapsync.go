@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 	"time"
@@ -9,6 +10,7 @@ import (
 	ldapsync "github.com/isbm/uyuni-ldap-sync"
 	"github.com/sirupsen/logrus"
 	easy "github.com/t-tomalak/logrus-easy-formatter"
+	"github.com/thoas/go-funk"
 	"github.com/urfave/cli"
 )
 
@@ -28,10 +30,18 @@ func NewSyncApp(ctx *cli.Context) *SyncApp {
 	return sa
 }
 
+// Get an option value, regardless if it was specified globally or for the command
+func (sa *SyncApp) option(name string) string {
+	if value := sa.cliContext.String(name); value != "" {
+		return value
+	}
+	return sa.cliContext.GlobalString(name)
+}
+
 // SetupLogger is used to setup all the preferences for the logging
 func (sa *SyncApp) setupLogger(cr *ldapsync.ConfigReader) {
 	log = ldapsync.Log
-	if !sa.cliContext.Bool("verbose") {
+	if !sa.cliContext.Bool("verbose") && !sa.cliContext.GlobalBool("verbose") {
 		fmtr := new(easy.Formatter)
 		fmtr.TimestampFormat = "2006-01-02 15:04:05"
 		fmtr.LogFormat = "[%lvl%]: %time% - %msg%\n"
@@ -60,8 +70,10 @@ func (sa *SyncApp) setupLogger(cr *ldapsync.ConfigReader) {
 // Creates new, if not yet initialised.
 func (sa *SyncApp) GetLDAPSync() *ldapsync.LDAPSync {
 	if sa.ldapSync == nil {
-		sa.ldapSync = ldapsync.NewLDAPSync(sa.cliContext.String("config"))
+		sa.ldapSync = ldapsync.NewLDAPSync(sa.option("config"))
 		sa.setupLogger(sa.ldapSync.ConfigReader())
+		sa.ldapSync.Select(append(sa.cliContext.StringSlice("user"), sa.cliContext.GlobalStringSlice("user")...),
+			sa.option("filter"))
 		sa.ldapSync.Start()
 	}
 
@@ -75,41 +87,144 @@ func (sa *SyncApp) Finish() {
 	}
 }
 
-// PrintUsers prints users information to the STDOUT
-func PrintUsers(title string, users []*ldapsync.UyuniUser) {
-	if len(users) > 0 {
-		fmt.Printf("%s:\n", title)
-		for idx, user := range users {
-			idx++
-			fmt.Printf("  %d. %s (%s %s) at %s\n", idx, user.Uid, user.Name, user.Secondname, user.Email)
-		}
-		fmt.Println()
-	} else {
-		fmt.Printf("No %s has been found for this criteria\n", strings.ToLower(title))
+// Format returns the requested output format
+func (sa *SyncApp) Format(formats ...string) (string, error) {
+	format := strings.ToLower(sa.option("format"))
+	if !funk.ContainsString(formats, format) {
+		return "", cli.NewExitError(fmt.Sprintf("Unknown output format: %s", format), ldapsync.ExitAborted)
 	}
+	return format, nil
 }
 
 // RunSync is a main sync runner
-func RunSync(ctx *cli.Context) {
+func RunSync(ctx *cli.Context) error {
 	lc := NewSyncApp(ctx)
+	defer lc.Finish()
+
+	format, err := lc.Format(FormatText, FormatJSON, FormatYAML, FormatCSV)
+	if err != nil {
+		return err
+	}
+
 	if ctx.Bool("overview") {
-		// TODO: add reporting facility instead of this
-		fmt.Println("Ignored users:")
-		for idx, uid := range lc.GetLDAPSync().ConfigReader().Config().Directory.Frozen {
-			idx++
-			fmt.Printf("  %d. %s\n", idx, uid)
+		if err := RenderOverview(os.Stdout, format, lc.GetLDAPSync().Overview()); err != nil {
+			return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
 		}
-		fmt.Println()
-
-		PrintUsers("New users", lc.GetLDAPSync().GetNewUsers())
-		PrintUsers("Outdated users", lc.GetLDAPSync().GetOutdatedUsers())
-		PrintUsers("Removed users", lc.GetLDAPSync().GetDeletedUsers())
 	} else if ctx.Bool("sync") {
-		lc.GetLDAPSync().SyncUsers()
+		report := lc.GetLDAPSync().SyncUsers()
+		if err := RenderReport(os.Stdout, format, report); err != nil {
+			return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+		}
+		if report.ExitCode() != ldapsync.ExitClean {
+			return cli.NewExitError("", report.ExitCode())
+		}
 	} else {
 		cli.ShowAppHelpAndExit(ctx, 1)
 	}
-	lc.Finish()
+
+	return nil
+}
+
+// RunExplain explains why the user has its roles
+func RunExplain(ctx *cli.Context) error {
+	if ctx.NArg() != 1 {
+		return cli.NewExitError("Exactly one user ID is required", ldapsync.ExitAborted)
+	}
+
+	lc := NewSyncApp(ctx)
+	defer lc.Finish()
+
+	format, err := lc.Format(FormatText, FormatJSON, FormatYAML)
+	if err != nil {
+		return err
+	}
+
+	if err := RenderExplanation(os.Stdout, format, lc.GetLDAPSync().Explain(ctx.Args().First())); err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+
+	return nil
+}
+
+// RunExport exports current Uyuni roles as LDIF of LDAP groups and a matching configuration
+func RunExport(ctx *cli.Context) error {
+	if ctx.String("base") == "" {
+		return cli.NewExitError("Base DN for the groups is required", ldapsync.ExitAborted)
+	}
+
+	lc := NewSyncApp(ctx)
+	defer lc.Finish()
+
+	export, err := lc.GetLDAPSync().ExportGroups(ctx.String("base"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+	snippet, err := export.YAML()
+	if err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+
+	ldif := os.Stdout
+	if ctx.String("ldif") != "-" {
+		if ldif, err = os.Create(ctx.String("ldif")); err != nil {
+			return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+		}
+		defer ldif.Close()
+	}
+	if err = export.WriteLDIF(ldif); err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+
+	if ctx.String("yaml") != "" {
+		err = ioutil.WriteFile(ctx.String("yaml"), snippet, 0640)
+	} else {
+		// Keep LDIF valid, if the snippet goes along
+		_, err = fmt.Fprintf(ldif, "# Matching configuration:\n#\n# %s\n",
+			strings.Join(strings.Split(strings.TrimSpace(string(snippet)), "\n"), "\n# "))
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+
+	return nil
+}
+
+// RunAdopt marks existing Uyuni users as managed by the sync
+func RunAdopt(ctx *cli.Context) error {
+	if ctx.NArg() == 0 && !ctx.Bool("all") {
+		return cli.NewExitError("Either user IDs or --all is required", ldapsync.ExitAborted)
+	}
+
+	lc := NewSyncApp(ctx)
+	defer lc.Finish()
+
+	format, err := lc.Format(FormatText, FormatJSON, FormatYAML)
+	if err != nil {
+		return err
+	}
+
+	adopted, err := lc.GetLDAPSync().Adopt(ctx.Args(), ctx.Bool("all"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+
+	if err := RenderAdopted(os.Stdout, format, adopted); err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+
+	return nil
+}
+
+// RunSCIM starts the SCIM endpoint, receiving provisioning pushes
+func RunSCIM(ctx *cli.Context) error {
+	lc := NewSyncApp(ctx)
+	defer lc.Finish()
+
+	if err := ldapsync.NewSCIMServer(lc.GetLDAPSync()).ListenAndServe(); err != nil {
+		return cli.NewExitError(err.Error(), ldapsync.ExitAborted)
+	}
+
+	return nil
 }
 
 // Main function
@@ -118,6 +233,7 @@ func main() {
 	app.Name = "LDAP Sync"
 	app.Usage = "Synchronise users between Uyuni/SUSE Manager and LDAP of your choice"
 	app.Action = RunSync
+	ldapsync.Log.ExitFunc = func(int) { os.Exit(ldapsync.ExitAborted) }
 	app.Version = "0.1"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
@@ -135,6 +251,19 @@ func main() {
 			Usage:  "Synchronise users",
 			Hidden: false,
 		},
+		cli.StringSliceFlag{
+			Name:  "user, u",
+			Usage: "Restrict the sync to the given user ID (repeatable)",
+		},
+		cli.StringFlag{
+			Name:  "filter",
+			Usage: "Restrict the sync to the users, matching the LDAP filter",
+		},
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: FormatText,
+			Usage: "Output format: text, json, yaml or csv",
+		},
 		cli.BoolFlag{
 			Name:   "verbose, d",
 			Usage:  "Verbose (debug) mode",
@@ -142,8 +271,54 @@ func main() {
 		},
 	}
 
+	app.Commands = []cli.Command{
+		{
+			Name:      "explain",
+			Usage:     "Explain why the user has its roles and what the next sync would do to it",
+			ArgsUsage: "<uid>",
+			Action:    RunExplain,
+		},
+		{
+			Name:      "adopt",
+			Usage:     "Let the sync manage existing Uyuni users, it has not created",
+			ArgsUsage: "[uid...]",
+			Action:    RunAdopt,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all, a",
+					Usage: "Adopt all Uyuni users, having an entry in the directory",
+				},
+			},
+		},
+		{
+			Name:   "scim",
+			Usage:  "Run SCIM 2.0 endpoint, receiving users and groups from the identity provider",
+			Action: RunSCIM,
+		},
+		{
+			Name:   "export",
+			Usage:  "Export current Uyuni roles as LDIF of LDAP groups and a matching configuration",
+			Action: RunExport,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "base, b",
+					Usage: "Existing DN where the groups are created, e.g. ou=Groups,dc=example,dc=com",
+				},
+				cli.StringFlag{
+					Name:  "ldif",
+					Value: "-",
+					Usage: "LDIF output file",
+				},
+				cli.StringFlag{
+					Name:  "yaml",
+					Usage: "Configuration snippet output file. If not specified, appended to LDIF as a comment",
+				},
+			},
+		},
+	}
+
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		ldapsync.Log.Fatal(err)
 	}
 }
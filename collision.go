@@ -0,0 +1,126 @@
+package ldapsync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policies of resolving login collisions
+const (
+	CollisionSkip   = "skip"
+	CollisionPrefer = "prefer"
+	CollisionFail   = "fail"
+)
+
+// LoginCollision describes several directory entries, resolving to the same login.
+// Chosen is the DN of the entry, taken for the sync, or empty if all of them are skipped.
+type LoginCollision struct {
+	Uid    string   `json:"uid" yaml:"uid"`
+	Dns    []string `json:"dns" yaml:"dns"`
+	Chosen string   `json:"chosen" yaml:"chosen"`
+}
+
+// Detect users of the directory with the same login and resolve them by the configured policy.
+// Entries that lost are removed from both staged and all LDAP users,
+// so they are neither created nor deleted in Uyuni.
+func (sync *LDAPSync) resolveCollisions() error {
+	sync.collisions = make([]*LoginCollision, 0)
+
+	logins := make([]string, 0)
+	dns := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, users := range [][]*UyuniUser{sync.ldapusers, sync.allldapusers} {
+		for _, user := range users {
+			if user.Uid == "" || seen[CanonicalDN(user.Dn)] {
+				continue
+			}
+			seen[CanonicalDN(user.Dn)] = true
+
+			key := sync.loginKey(user.Uid)
+			if _, ext := dns[key]; !ext {
+				logins = append(logins, key)
+			}
+			dns[key] = append(dns[key], user.Dn)
+		}
+	}
+
+	losers := make(map[string]bool)
+	for _, key := range logins {
+		if len(dns[key]) < 2 {
+			continue
+		}
+
+		collision := &LoginCollision{Uid: key, Dns: dns[key]}
+		switch sync.cr.Config().Directory.Collisions {
+		case CollisionFail:
+			return fmt.Errorf("Login '%s' is claimed by several entries: %s", key, strings.Join(collision.Dns, "; "))
+		case CollisionPrefer:
+			collision.Chosen = sync.preferredDN(collision.Dns)
+		}
+
+		for _, dn := range collision.Dns {
+			if !EqualDN(dn, collision.Chosen) {
+				losers[CanonicalDN(dn)] = true
+			}
+		}
+
+		if collision.Chosen != "" {
+			Log.Warnf("Login '%s' is claimed by several entries: %s. Taking '%s'", key, strings.Join(collision.Dns, "; "), collision.Chosen)
+		} else {
+			Log.Warnf("Login '%s' is claimed by several entries: %s. Skipping all of them", key, strings.Join(collision.Dns, "; "))
+		}
+		sync.collisions = append(sync.collisions, collision)
+	}
+
+	if len(losers) == 0 {
+		return nil
+	}
+
+	filter := func(users []*UyuniUser) []*UyuniUser {
+		kept := make([]*UyuniUser, 0, len(users))
+		for _, user := range users {
+			if !losers[CanonicalDN(user.Dn)] {
+				kept = append(kept, user)
+			}
+		}
+		return kept
+	}
+	sync.ldapusers = filter(sync.ldapusers)
+	sync.allldapusers = filter(sync.allldapusers)
+
+	return nil
+}
+
+// Get the DN under the earliest user base. If there is no single such DN, nothing is preferred.
+func (sync *LDAPSync) preferredDN(dns []string) string {
+	bases := sync.cr.Config().Directory.Userbases
+	rank := func(dn string) int {
+		for idx, base := range bases {
+			if DNDepthUnder(dn, base.Dn) >= 0 {
+				return idx
+			}
+		}
+		return len(bases)
+	}
+
+	preferred, best, tie := "", len(bases)+1, false
+	for _, dn := range dns {
+		switch r := rank(dn); {
+		case r < best:
+			preferred, best, tie = dn, r, false
+		case r == best:
+			tie = true
+		}
+	}
+
+	if tie {
+		return ""
+	}
+
+	return preferred
+}
+
+// Collisions returns login collisions, detected in the directory
+func (sync *LDAPSync) Collisions() []*LoginCollision {
+	return sync.collisions
+}
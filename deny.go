@@ -0,0 +1,103 @@
+package ldapsync
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/thoas/go-funk"
+)
+
+// RoleDenial describes a deny mapping, matching the user. It is effective, if it removed the role.
+// Otherwise it is overridden by a grant of the higher precedence or by "org_admin", implying the role,
+// or the user has not been granted the role at all.
+type RoleDenial struct {
+	Role         string   `json:"role" yaml:"role"`
+	Source       string   `json:"source" yaml:"source"`
+	Mapping      string   `json:"mapping" yaml:"mapping"`
+	Path         []string `json:"path" yaml:"path"`
+	Effective    bool     `json:"effective" yaml:"effective"`
+	OverriddenBy string   `json:"overridden_by,omitempty" yaml:"overridden_by,omitempty"`
+}
+
+// Get the precedence of the mapping DN. Lower is stronger, DNs not listed are the weakest.
+func (sync *LDAPSync) precedence(dn string) int {
+	for idx, pdn := range sync.cr.Config().Directory.Precedence {
+		if EqualDN(pdn, dn) {
+			return idx
+		}
+	}
+
+	return len(sync.cr.Config().Directory.Precedence)
+}
+
+// Apply deny mappings to the collected grants of the user. A denial removes all grants of the role,
+// unless one of them has the higher precedence. Roles are then rebuilt from the remaining grants.
+// A role, implied by the remaining "org_admin", cannot be denied.
+func (sync *LDAPSync) applyDenials(user *UyuniUser) {
+	user.denials = nil
+	if len(sync.cr.Config().Directory.Deny) == 0 {
+		return
+	}
+
+	dns := make([]string, 0)
+	for dn := range sync.cr.Config().Directory.Deny {
+		dns = append(dns, dn)
+	}
+	sort.Strings(dns)
+
+	for _, dn := range dns {
+		for _, searchConfig := range sync.roleConfigs {
+			for _, path := range sync.membershipPaths(dn, user, searchConfig) {
+				for _, role := range sync.cr.Config().Directory.Deny[dn] {
+					user.denials = append(user.denials, &RoleDenial{Role: strings.ToLower(role), Source: searchConfig.name,
+						Mapping: dn, Path: path})
+				}
+			}
+		}
+	}
+
+	if len(user.denials) == 0 {
+		return
+	}
+
+	before := append([]string{}, user.GetRoles()...)
+	denied := make(map[string]bool)
+	for _, denial := range user.denials {
+		for _, grant := range user.grants {
+			if strings.EqualFold(grant.Role, denial.Role) && sync.precedence(grant.Mapping) < sync.precedence(denial.Mapping) {
+				denial.OverriddenBy = grant.Mapping
+				break
+			}
+		}
+		if denial.OverriddenBy == "" {
+			denied[denial.Role] = true
+		}
+	}
+
+	grants := make([]*RoleGrant, 0, len(user.grants))
+	for _, grant := range user.grants {
+		if !denied[strings.ToLower(grant.Role)] {
+			grants = append(grants, grant)
+		}
+	}
+	user.grants = grants
+
+	user.FlushRoles()
+	for _, grant := range user.grants {
+		user.AddRoles(grant.Role)
+	}
+
+	// Effective are only the denials, that actually removed the role
+	for _, denial := range user.denials {
+		if denial.OverriddenBy != "" || !funk.ContainsString(before, denial.Role) {
+			continue
+		}
+		if funk.ContainsString(user.GetRoles(), denial.Role) {
+			denial.OverriddenBy = "org_admin"
+			Log.Warnf("Role '%s' of user %s cannot be denied by '%s': it is implied by org_admin", denial.Role, user.Uid, denial.Mapping)
+			continue
+		}
+		denial.Effective = true
+		Log.Debugf("Role '%s' of user %s is denied by '%s'", denial.Role, user.Uid, denial.Mapping)
+	}
+}
@@ -0,0 +1,107 @@
+package ldapsync
+
+import "strings"
+
+// Actions the next sync is going to perform on the user, besides the sync operations
+const (
+	ActionNone      = "none"
+	ActionIgnore    = "ignore"
+	ActionUnmanaged = "unmanaged"
+)
+
+// RoleExplanation lists all the mappings that contributed the role.
+// Roles, implied by another role (e.g. by "org_admin") have no mappings of their own.
+type RoleExplanation struct {
+	Role      string       `json:"role" yaml:"role"`
+	ImpliedBy string       `json:"implied_by,omitempty" yaml:"implied_by,omitempty"`
+	Grants    []*RoleGrant `json:"grants" yaml:"grants"`
+}
+
+// Explanation describes why the user has its roles and what the next sync is going to do with it
+type Explanation struct {
+	Uid      string             `json:"uid" yaml:"uid"`
+	Dn       string             `json:"dn" yaml:"dn"`
+	InLDAP   bool               `json:"in_ldap" yaml:"in_ldap"`
+	InUyuni  bool               `json:"in_uyuni" yaml:"in_uyuni"`
+	Frozen   bool               `json:"frozen" yaml:"frozen"`
+	Roles    []*RoleExplanation `json:"roles" yaml:"roles"`
+	Denials  []*RoleDenial      `json:"denials,omitempty" yaml:"denials,omitempty"`
+	Override *AppliedOverride   `json:"override,omitempty" yaml:"override,omitempty"`
+	Action   string             `json:"action" yaml:"action"`
+	Pending  *UserOverview      `json:"pending,omitempty" yaml:"pending,omitempty"`
+}
+
+// Explain resolves the user by the UID and explains its roles and pending changes
+func (sync *LDAPSync) Explain(uid string) *Explanation {
+	explanation := new(Explanation)
+	explanation.Uid = uid
+	explanation.Roles = make([]*RoleExplanation, 0)
+	explanation.Frozen = sync.isFrozen(uid)
+	explanation.InUyuni = sync.uyuniLogins[sync.loginKey(uid)] || sync.in(UyuniUser{Uid: uid}, sync.uyuniusers)
+	explanation.Action = ActionNone
+
+	for _, users := range [][]*UyuniUser{sync.ldapusers, sync.allldapusers} {
+		for _, ldapUser := range users {
+			if sync.sameLogin(ldapUser.Uid, uid) && ldapUser.Dn != "" {
+				explanation.Dn = ldapUser.Dn
+				goto Found
+			}
+		}
+	}
+Found:
+	if explanation.Dn != "" {
+		user := sync.newUserFromDN(explanation.Dn)
+		explanation.InLDAP = user.Uid != ""
+		sync.updateLDAPUserRoles(user)
+		sync.applyOverride(user)
+		explanation.Denials = user.GetDenials()
+		explanation.Override = user.GetOverride()
+
+		orgAdmin := false
+		for _, grant := range user.GetGrants() {
+			orgAdmin = orgAdmin || strings.EqualFold(grant.Role, "org_admin")
+		}
+
+		for _, role := range user.GetRoles() {
+			roleExplanation := &RoleExplanation{Role: role, Grants: make([]*RoleGrant, 0)}
+			for _, grant := range user.GetGrants() {
+				if strings.EqualFold(grant.Role, role) {
+					roleExplanation.Grants = append(roleExplanation.Grants, grant)
+				}
+			}
+			if len(roleExplanation.Grants) == 0 && orgAdmin {
+				roleExplanation.ImpliedBy = "org_admin"
+			}
+			explanation.Roles = append(explanation.Roles, roleExplanation)
+		}
+	}
+
+	if explanation.Frozen {
+		explanation.Action = ActionIgnore
+		return explanation
+	}
+
+	overview := sync.Overview()
+	for _, pending := range overview.Unmanaged {
+		if sync.sameLogin(pending.Uid, uid) {
+			explanation.Pending = pending
+			explanation.Action = ActionUnmanaged
+		}
+	}
+
+	for _, pending := range overview.Users {
+		if sync.sameLogin(pending.Uid, uid) {
+			explanation.Pending = pending
+			switch pending.Status {
+			case StatusNew:
+				explanation.Action = ActionCreate
+			case StatusOutdated:
+				explanation.Action = ActionUpdate
+			case StatusRemoved:
+				explanation.Action = ActionDelete
+			}
+		}
+	}
+
+	return explanation
+}
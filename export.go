@@ -0,0 +1,154 @@
+package ldapsync
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap"
+	"github.com/go-yaml/yaml"
+	"github.com/thoas/go-funk"
+)
+
+// GroupExport is a groupOfNames entry for the users, sharing the same role combination
+type GroupExport struct {
+	Dn      string
+	Cn      string
+	Roles   []string
+	Members []string
+	Exists  bool
+}
+
+// Export of the current Uyuni roles as LDAP groups
+type Export struct {
+	Groups  []*GroupExport
+	Skipped []string
+}
+
+// ExportGroups reads all users and roles from Uyuni and groups them by their role combinations
+// into groupOfNames entries under the given base DN.
+func (sync *LDAPSync) ExportGroups(base string) (*Export, error) {
+	// Uyuni users are re-read as they are, status is refreshed back afterwards
+	defer sync.refreshUyuniUsersStatus()
+
+	export := new(Export)
+	export.Groups = make([]*GroupExport, 0)
+	export.Skipped = make([]string, 0)
+
+	existing := make(map[string]bool)
+	request := ldap.NewSearchRequest(base, ldap.ScopeSingleLevel, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{}, nil)
+	for _, entry := range sync.lc.Search(request).Entries {
+		existing[CanonicalDN(entry.DN)] = true
+	}
+
+	users, err := sync.refreshExistingUyuniUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*GroupExport)
+	for _, user := range users {
+		var dn string
+		for _, ldapUser := range sync.allldapusers {
+			if sync.sameLogin(ldapUser.Uid, user.Uid) {
+				dn = ldapUser.Dn
+				break
+			}
+		}
+		if dn == "" {
+			Log.Warnf("User '%s' was not found in LDAP, skipping", user.Uid)
+			export.Skipped = append(export.Skipped, user.Uid)
+			continue
+		}
+
+		// Users without any role go to the group, mapped to an empty list of roles.
+		// They have to stay in the directory groups, otherwise the first sync would remove them.
+		roles := append([]string{}, user.GetRoles()...)
+		sort.Strings(roles)
+		cn := "uyuni-users"
+		if len(roles) > 0 {
+			// Org admin gets everything within the organisation anyway
+			if funk.ContainsString(roles, "org_admin") {
+				collapsed := []string{"org_admin"}
+				for _, role := range roles {
+					if IsServerRole(role) {
+						collapsed = append(collapsed, role)
+					}
+				}
+				roles = collapsed
+			}
+			cn = "uyuni-" + strings.Join(roles, "-")
+		}
+
+		group, ext := groups[cn]
+		if !ext {
+			group = &GroupExport{Cn: cn, Dn: fmt.Sprintf("cn=%s,%s", cn, base), Roles: roles}
+			group.Exists = existing[CanonicalDN(group.Dn)]
+			groups[cn] = group
+			export.Groups = append(export.Groups, group)
+		}
+		group.Members = append(group.Members, dn)
+	}
+
+	sort.Slice(export.Groups, func(i, j int) bool { return export.Groups[i].Cn < export.Groups[j].Cn })
+
+	return export, nil
+}
+
+// Format a single LDIF attribute line, base64-encoding unsafe values (RFC 2849)
+func ldifLine(attr string, value string) string {
+	safe := value == "" || (value[0] != ' ' && value[0] != ':' && value[0] != '<' && value[len(value)-1] != ' ')
+	for _, c := range value {
+		if c > 127 || c == '\n' || c == '\r' || c == 0 {
+			safe = false
+			break
+		}
+	}
+
+	if safe {
+		return fmt.Sprintf("%s: %s\n", attr, value)
+	}
+	return fmt.Sprintf("%s:: %s\n", attr, base64.StdEncoding.EncodeToString([]byte(value)))
+}
+
+// WriteLDIF writes the groups as LDIF, adding new groups and replacing members of the existing ones
+func (e *Export) WriteLDIF(out io.Writer) error {
+	for _, group := range e.Groups {
+		var buff strings.Builder
+		buff.WriteString(ldifLine("dn", group.Dn))
+		if group.Exists {
+			buff.WriteString("changetype: modify\n")
+			buff.WriteString("replace: member\n")
+		} else {
+			buff.WriteString("changetype: add\n")
+			buff.WriteString("objectClass: groupOfNames\n")
+			buff.WriteString(ldifLine("cn", group.Cn))
+		}
+		for _, member := range group.Members {
+			buff.WriteString(ldifLine("member", member))
+		}
+		if group.Exists {
+			buff.WriteString("-\n")
+		}
+		buff.WriteString("\n")
+
+		if _, err := io.WriteString(out, buff.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// YAML returns the matching "directory.groups" configuration snippet
+func (e *Export) YAML() ([]byte, error) {
+	groups := make(map[string][]string)
+	for _, group := range e.Groups {
+		groups[group.Dn] = group.Roles
+	}
+
+	return yaml.Marshal(map[string]interface{}{"directory": map[string]interface{}{"groups": groups}})
+}
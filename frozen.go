@@ -0,0 +1,233 @@
+package ldapsync
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap"
+	"github.com/thoas/go-funk"
+)
+
+// Returns true if the frozen entry is a regular expression, i.e. "/pattern/"
+func isFrozenRegexp(entry string) bool {
+	return len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/")
+}
+
+// Returns true if the frozen entry is a glob pattern, e.g. "svc-*"
+func isFrozenGlob(entry string) bool {
+	return strings.ContainsAny(entry, "*?[")
+}
+
+// Translate the glob pattern to a regular expression, matching the whole login.
+// Unlike path.Match, "*" and "?" match any character, including "/", as logins are not paths.
+func globExpr(glob string) (string, error) {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			start := i + 1
+			if start < len(glob) && (glob[start] == '!' || glob[start] == '^') {
+				start++
+			}
+			// The closing bracket right after the opening one is taken literally
+			end := -1
+			if start < len(glob) {
+				end = strings.IndexByte(glob[start+1:], ']')
+			}
+			if end < 0 {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			end += start + 1
+			class := strings.Replace(glob[start:end], "\\", "\\\\", -1)
+			if start > i+1 {
+				class = "^" + class
+			}
+			expr.WriteString("[" + class + "]")
+			i = end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return "^(?:" + expr.String() + ")$", nil
+}
+
+// Get the regular expression of the frozen entry, either a glob or "/pattern/"
+func frozenExpr(entry string) (string, error) {
+	if isFrozenRegexp(entry) {
+		return "^(?:" + entry[1:len(entry)-1] + ")$", nil
+	}
+
+	return globExpr(entry)
+}
+
+// ValidateFrozenEntry checks the frozen entry is either a login, a valid glob or a valid regular expression
+func ValidateFrozenEntry(entry string) error {
+	if !isFrozenRegexp(entry) && !isFrozenGlob(entry) {
+		return nil
+	}
+
+	expr, err := frozenExpr(entry)
+	if err == nil {
+		_, err = regexp.Compile(expr)
+	}
+	if err != nil {
+		if isFrozenRegexp(entry) {
+			return fmt.Errorf("invalid regular expression of the frozen users '%s': %s", entry, err.Error())
+		}
+		return fmt.Errorf("invalid pattern of the frozen users '%s': %s", entry, err.Error())
+	}
+
+	return nil
+}
+
+// Compile the frozen patterns. Both globs and regular expressions have to match the whole login.
+func (sync *LDAPSync) compileFrozenPatterns() {
+	sync.frozenRegexps = nil
+	for _, entry := range sync.cr.Config().Directory.Frozen {
+		if isFrozenRegexp(entry) || isFrozenGlob(entry) {
+			flags := ""
+			if sync.cr.Config().Directory.Logincase != LogincasePreserve {
+				flags = "(?i)"
+			}
+			expr, _ := frozenExpr(entry)
+			sync.frozenRegexps = append(sync.frozenRegexps, regexp.MustCompile(flags+expr))
+		}
+	}
+}
+
+// Returns true if the login matches any of the configured frozen entries
+func (sync *LDAPSync) matchesFrozen(uid string) bool {
+	for _, entry := range sync.cr.Config().Directory.Frozen {
+		if !isFrozenRegexp(entry) && !isFrozenGlob(entry) && sync.sameLogin(entry, uid) {
+			return true
+		}
+	}
+
+	for _, re := range sync.frozenRegexps {
+		if re.MatchString(uid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true if the user is frozen and should be completely ignored
+func (sync *LDAPSync) isFrozen(uid string) bool {
+	return sync.frozen[sync.loginKey(uid)] || sync.matchesFrozen(uid)
+}
+
+// Resolve all frozen logins: configured ones, Uyuni users matching the patterns,
+// members of the frozen LDAP groups and Uyuni users with the frozen roles.
+func (sync *LDAPSync) refreshFrozenUsers() error {
+	sync.compileFrozenPatterns()
+	sync.frozen = make(map[string]bool)
+	sync.uyuniRoles = make(map[string][]string)
+
+	for _, entry := range sync.cr.Config().Directory.Frozen {
+		if !isFrozenRegexp(entry) && !isFrozenGlob(entry) {
+			sync.frozen[sync.loginKey(entry)] = true
+		}
+	}
+
+	res, err := sync.uc.Call("user.listUsers", sync.uc.Session())
+	if err != nil {
+		return fmt.Errorf("Unable to get Uyuni users: %s", err.Error())
+	}
+	all := make([]string, 0)
+	logins := make([]string, 0)
+	for _, usrdata := range res.([]interface{}) {
+		uid := usrdata.(map[string]interface{})["login"].(string)
+		all = append(all, uid)
+		if sync.matchesFrozen(uid) {
+			sync.frozen[sync.loginKey(uid)] = true
+		} else {
+			logins = append(logins, uid)
+		}
+	}
+
+	for _, gdn := range sync.cr.Config().Directory.Frozengroups {
+		request := ldap.NewSearchRequest(gdn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+			"(objectClass=*)", []string{}, nil)
+		entries := sync.lc.Search(request).Entries
+		if len(entries) == 0 {
+			Log.Errorf("Frozen group '%s' was not found", gdn)
+		}
+		for _, entry := range entries {
+			for _, udn := range append(entry.GetAttributeValues("member"), entry.GetAttributeValues("roleOccupant")...) {
+				if user := sync.newUserFromDN(udn); user.Uid != "" {
+					sync.frozen[sync.loginKey(user.Uid)] = true
+				}
+			}
+		}
+	}
+
+	// Roles are kept for reading the Uyuni users later, so they are not fetched twice
+	if roles := sync.cr.Config().Directory.Frozenroles; len(roles) > 0 {
+		userRoles := make([][]string, len(logins))
+		errs := sync.pool.Run(len(logins), func(idx int) error {
+			res, err := sync.uc.Call("user.listRoles", sync.uc.Session(), logins[idx])
+			if err != nil {
+				return err
+			}
+			userRoles[idx] = make([]string, 0)
+			for _, role := range res.([]interface{}) {
+				userRoles[idx] = append(userRoles[idx], role.(string))
+			}
+			return nil
+		})
+
+		for idx, login := range logins {
+			if errs[idx] != nil {
+				return fmt.Errorf("Unable to get roles of Uyuni user '%s': %s", login, errs[idx].Error())
+			}
+			sync.uyuniRoles[login] = userRoles[idx]
+			for _, role := range userRoles[idx] {
+				if funk.ContainsString(roles, role) {
+					sync.frozen[sync.loginKey(login)] = true
+				}
+			}
+		}
+	}
+
+	sync.frozenUyuni = make([]string, 0)
+	sync.uyuniLogins = make(map[string]bool)
+	for _, uid := range all {
+		sync.uyuniLogins[sync.loginKey(uid)] = true
+		if sync.frozen[sync.loginKey(uid)] {
+			sync.frozenUyuni = append(sync.frozenUyuni, uid)
+		}
+	}
+	for _, entry := range sync.cr.Config().Directory.Frozen {
+		if !isFrozenRegexp(entry) && !isFrozenGlob(entry) && !sync.uyuniLogins[sync.loginKey(entry)] {
+			Log.Errorf("No users has been found with the UID '%s'", entry)
+		}
+	}
+
+	Log.Debugf("Found %d frozen users, %d of them in Uyuni", len(sync.frozen), len(sync.frozenUyuni))
+
+	return nil
+}
+
+// FrozenUsers returns all resolved frozen logins
+func (sync *LDAPSync) FrozenUsers() []string {
+	logins := make([]string, 0, len(sync.frozen))
+	for login := range sync.frozen {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+
+	return logins
+}
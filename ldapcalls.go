@@ -12,6 +12,7 @@ type LDAPCaller struct {
 	host     string
 	proto    string
 	port     int64
+	servers  []string
 	conn     *ldap.Conn
 }
 
@@ -54,14 +55,34 @@ func (lc *LDAPCaller) SetHost(host string) *LDAPCaller {
 	return lc
 }
 
+// SetServers sets a list of "host:port" addresses to try in the given order.
+// If set, it takes over the host and port.
+func (lc *LDAPCaller) SetServers(servers ...string) *LDAPCaller {
+	lc.servers = servers
+	return lc
+}
+
 // Connect  to the LDAP
 func (lc *LDAPCaller) Connect() {
 	var err error
 	if lc.conn == nil {
-		lc.conn, err = ldap.Dial(lc.proto, fmt.Sprintf("%s:%d", lc.host, lc.port))
-		if err != nil {
-			Log.Fatal(err)
+		if len(lc.servers) == 0 {
+			lc.conn, err = ldap.Dial(lc.proto, fmt.Sprintf("%s:%d", lc.host, lc.port))
+			if err != nil {
+				Log.Fatal(err)
+			}
+			return
+		}
+
+		for _, addr := range lc.servers {
+			lc.conn, err = ldap.Dial(lc.proto, addr)
+			if err == nil {
+				Log.Debugf("Connected to LDAP server at %s", addr)
+				return
+			}
+			Log.Warnf("Unable to connect to LDAP server at %s: %s", addr, err.Error())
 		}
+		Log.Fatal("None of the discovered LDAP servers are reachable")
 	}
 }
 
@@ -1,12 +1,14 @@
 package ldapsync
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/go-ldap/ldap"
 	"github.com/sirupsen/logrus"
-	"github.com/thoas/go-funk"
 )
 
 var Log *logrus.Logger
@@ -17,6 +19,7 @@ func init() {
 
 // SearchConfig object
 type SearchConfig struct {
+	name      string
 	config    *map[string][]string
 	filter    string
 	attribute string
@@ -24,59 +27,216 @@ type SearchConfig struct {
 
 // LDAPSync object
 type LDAPSync struct {
-	lc           *LDAPCaller
+	lc           DirectorySource
 	uc           *UyuniCaller
+	pool         *WorkerPool
 	cr           *ConfigReader
 	ldapusers    []*UyuniUser
 	uyuniusers   []*UyuniUser
 	allldapusers []*UyuniUser
 	roleConfigs  [2]*SearchConfig
+	selectUids   []string
+	selectFilter string
+	selected     map[string]bool
+	firstname    *NameTemplate
+	lastname     *NameTemplate
+	transforms   map[string]TransformChain
+	collisions   []*LoginCollision
+	renames      []*LoginRename
+	state        *SyncState
+	overrides    *UserOverrides
+
+	frozen        map[string]bool
+	frozenRegexps []*regexp.Regexp
+	frozenUyuni   []string
+	uyuniLogins   map[string]bool
+	serverRoles   bool
+	orgAdminRoles []string
+	uyuniRoles    map[string][]string
 }
 
 // NewLDAPSync creates an instance of LDAPSync
 func NewLDAPSync(cfgpath string) *LDAPSync {
 	sync := new(LDAPSync)
 	sync.cr = NewConfigReader(cfgpath)
-	sync.lc = NewLDAPCaller().
-		SetHost(sync.cr.Config().Directory.Host).
-		SetPort(sync.cr.Config().Directory.Port).
-		SetUser(sync.cr.Config().Directory.User).
-		SetPassword(sync.cr.Config().Directory.Password)
-
+	sync.lc = sync.newDirectorySource()
 	sync.uc = NewUyuniCaller(sync.cr.Config().Spacewalk.Url, !sync.cr.Config().Spacewalk.Checkssl).
+		SetConcurrency(sync.cr.Config().Spacewalk.Workers).
+		SetRateLimit(sync.cr.Config().Spacewalk.Ratelimit).
 		SetUser(sync.cr.Config().Spacewalk.User).
 		SetPassword(sync.cr.Config().Spacewalk.Password)
+	sync.pool = NewWorkerPool(sync.cr.Config().Spacewalk.Workers)
+	sync.state = NewSyncState(sync.cr.Config().Common.Statepath)
+	sync.overrides = NewUserOverrides(sync.cr.Config().Common.Overridespath)
 	sync.ldapusers = make([]*UyuniUser, 0)
 	sync.uyuniusers = make([]*UyuniUser, 0)
 	sync.allldapusers = make([]*UyuniUser, 0)
+	sync.firstname, _ = ParseNameTemplate(sync.cr.Config().Directory.Names.Firstname)
+	sync.lastname, _ = ParseNameTemplate(sync.cr.Config().Directory.Names.Lastname)
+	sync.transforms = make(map[string]TransformChain)
+	for field, specs := range sync.cr.Config().Directory.Transforms {
+		sync.transforms[field], _ = ParseTransformChain(specs)
+	}
 
 	sync.roleConfigs = [2]*SearchConfig{
-		&SearchConfig{config: &sync.cr.Config().Directory.Roles,
+		&SearchConfig{name: "roles", config: &sync.cr.Config().Directory.Roles,
 			filter: "(objectClass=organizationalRole)", attribute: "roleOccupant"},
-		&SearchConfig{config: &sync.cr.Config().Directory.Groups,
+		&SearchConfig{name: "groups", config: &sync.cr.Config().Directory.Groups,
 			filter: "(|(objectClass=groupOfNames)(objectClass=group))", attribute: "member"},
 	}
 	return sync
 }
 
+// Create the directory source, as configured
+func (sync *LDAPSync) newDirectorySource() DirectorySource {
+	switch sync.cr.Config().Directory.Source {
+	case SourceLDIF:
+		return NewLDIFDirectory(sync.cr.Config().Directory.Sourcepath)
+	case SourceCSV, SourceYAML:
+		return NewStaticDirectory(sync.cr.Config().Directory.Sourcepath, sync.cr.Config().Directory.Source,
+			sync.cr.Config().Directory.Allusers, sync.cr.Config().Directory.Groupsbase)
+	case SourceSCIM:
+		return NewMemoryDirectory()
+	}
+
+	lc := NewLDAPCaller().
+		SetHost(sync.cr.Config().Directory.Host).
+		SetPort(sync.cr.Config().Directory.Port).
+		SetUser(sync.cr.Config().Directory.User).
+		SetPassword(sync.cr.Config().Directory.Password)
+
+	if sync.cr.Config().Directory.Srvdomain != "" {
+		servers, err := NewLDAPServerLocator(sync.cr.Config().Directory.Srvdomain).Servers()
+		if err != nil {
+			Log.Fatalf("Unable to discover LDAP servers: %s", err.Error())
+		}
+		lc.SetServers(servers...)
+	}
+
+	return lc
+}
+
+// Directory returns the directory source
+func (sync *LDAPSync) Directory() DirectorySource {
+	return sync.lc
+}
+
 // ConfigReader returns a ConfigReader instance class
 func (sync *LDAPSync) ConfigReader() *ConfigReader {
 	return sync.cr
 }
 
-// Start LDAP sync process
+// Start LDAP sync process. Exits on any error, see Refresh.
 func (sync *LDAPSync) Start() *LDAPSync {
+	if err := sync.Refresh(); err != nil {
+		Log.Fatal(err)
+	}
+
+	return sync
+}
+
+// Refresh reads the state, the directory and Uyuni again, so the next sync is up to date.
+// Errors are returned instead of exiting, so long-running callers can retry later.
+func (sync *LDAPSync) Refresh() error {
 	sync.lc.Connect()
+	if err := sync.state.Load(); err != nil {
+		return fmt.Errorf("Unable to load the sync state: %s", err.Error())
+	}
+	if err := sync.overrides.Load(); err != nil {
+		return fmt.Errorf("Unable to load the user overrides: %s", err.Error())
+	}
 
-	sync.verifyIgnoredUsers()
-	sync.refreshExistingUyuniUsers()
+	if err := sync.refreshFrozenUsers(); err != nil {
+		return err
+	}
+	if err := sync.verifyIgnoredUsers(); err != nil {
+		return err
+	}
+	if err := sync.verifyMappedRoles(); err != nil {
+		return err
+	}
+	if err := sync.refreshSelectedUsers(); err != nil {
+		return err
+	}
+	if _, err := sync.refreshExistingUyuniUsers(); err != nil {
+		return err
+	}
 	sync.refreshStagedLDAPUsers()
 	sync.refreshAllLDAPUsers()
+	sync.resolveRenames()
+	if err := sync.resolveCollisions(); err != nil {
+		return err
+	}
 	sync.refreshUyuniUsersStatus()
+	sync.verifyOverrides()
+
+	return nil
+}
 
+// Select restricts the sync to the given user IDs and/or users, matching the LDAP filter.
+// Should be called before the sync process is started.
+func (sync *LDAPSync) Select(uids []string, filter string) *LDAPSync {
+	sync.selectUids = uids
+	sync.selectFilter = filter
 	return sync
 }
 
+// Resolve users selection, if any
+func (sync *LDAPSync) refreshSelectedUsers() error {
+	sync.selected = nil
+	if len(sync.selectUids) == 0 && sync.selectFilter == "" {
+		return nil
+	}
+
+	sync.selected = make(map[string]bool)
+	for _, uid := range sync.selectUids {
+		sync.selected[sync.loginKey(uid)] = true
+	}
+
+	if sync.selectFilter != "" {
+		if _, err := ldap.CompileFilter(sync.selectFilter); err != nil {
+			return fmt.Errorf("Invalid LDAP filter '%s': %s", sync.selectFilter, err.Error())
+		}
+
+		for _, dn := range sync.searchUserDNs(sync.selectFilter) {
+			if user := sync.newUserFromDN(dn); user.Uid != "" {
+				sync.selected[sync.loginKey(user.Uid)] = true
+			}
+		}
+	}
+
+	Log.Debugf("Sync is restricted to %d selected users", len(sync.selected))
+
+	return nil
+}
+
+// Returns true if the user is selected for the sync
+func (sync *LDAPSync) isSelected(uid string) bool {
+	return sync.selected == nil || sync.selected[sync.loginKey(uid)]
+}
+
+// Fold the case of the login from the directory, as configured
+func (sync *LDAPSync) foldLogin(uid string) string {
+	if sync.cr.Config().Directory.Logincase == LogincaseLower {
+		return strings.ToLower(uid)
+	}
+	return uid
+}
+
+// Get the key of the login to compare it with the others.
+// Folded logins are compared case-insensitively.
+func (sync *LDAPSync) loginKey(uid string) string {
+	if sync.cr.Config().Directory.Logincase != LogincasePreserve {
+		return strings.ToLower(uid)
+	}
+	return uid
+}
+
+// Returns true if the logins are the same
+func (sync *LDAPSync) sameLogin(a string, b string) bool {
+	return sync.loginKey(a) == sync.loginKey(b)
+}
+
 // Finish LDAP sync process.
 func (sync *LDAPSync) Finish() {
 	sync.lc.Disconnect()
@@ -85,7 +245,7 @@ func (sync *LDAPSync) Finish() {
 // Helper function that looks for the same user or at least its ID
 func (sync LDAPSync) in(user UyuniUser, users []*UyuniUser) bool {
 	for _, u := range users {
-		if u.Uid == user.Uid {
+		if sync.sameLogin(u.Uid, user.Uid) {
 			return true
 		}
 	}
@@ -93,37 +253,31 @@ func (sync LDAPSync) in(user UyuniUser, users []*UyuniUser) bool {
 }
 
 // Match a given user by a DN, compare all metadata.
+// All differing fields and roles are recorded in the user.
 func (sync LDAPSync) sameAsIn(user *UyuniUser, users []*UyuniUser) (bool, error) {
 	for _, u := range users {
-		if u.Uid == user.Uid {
-			same := u.Email == user.Email
-			if same {
-				same = u.Name == user.Name
-			} else {
-				user.accountchanged = true
-				Log.Debugf("User %s email has been changed from %s to %s", user.Uid, user.Email, u.Email)
-			}
-
-			if same {
-				same = u.Secondname == user.Secondname
-			} else {
-				user.accountchanged = true
-				Log.Debugf("User %s name has been changed from %s to %s", user.Uid, user.Name, u.Name)
-			}
-
-			if same {
-				same = CompareRoles(user, u)
-			} else {
-				user.accountchanged = true
-				Log.Debugf("User %s family name has been changed from %s to %s", user.Uid, user.Secondname, u.Secondname)
+		if sync.sameLogin(u.Uid, user.Uid) {
+			user.changes = nil
+			user.accountchanged, user.roleschanged = false, false
+			for _, change := range []*FieldChange{
+				{Field: "email", Before: u.Email, After: user.Email},
+				{Field: "name", Before: u.Name, After: user.Name},
+				{Field: "secondname", Before: u.Secondname, After: user.Secondname},
+			} {
+				if change.Before != change.After {
+					user.accountchanged = true
+					user.changes = append(user.changes, change)
+					Log.Debugf("User %s %s has been changed from %s to %s", user.Uid, change.Field, change.Before, change.After)
+				}
 			}
 
-			if !same {
+			user.rolesadded, user.rolesremoved = DiffRoles(u, user)
+			if len(user.rolesadded) > 0 || len(user.rolesremoved) > 0 {
 				user.roleschanged = true
 				Log.Debugf("User %s role set has been changed", user.Uid)
 			}
 
-			return same, nil
+			return !user.accountchanged && !user.roleschanged, nil
 		}
 	}
 
@@ -135,7 +289,7 @@ func (sync LDAPSync) sameAsIn(user *UyuniUser, users []*UyuniUser) (bool, error)
 // Returns a copy of LDAP user by Uyuni user
 func (sync *LDAPSync) updateFromLDAPUser(uyuniUser *UyuniUser) {
 	for _, ldapUser := range sync.ldapusers {
-		if ldapUser.Uid == uyuniUser.Uid {
+		if sync.sameLogin(ldapUser.Uid, uyuniUser.Uid) {
 			uyuniUser.Name, uyuniUser.Secondname, uyuniUser.Email = ldapUser.Name, ldapUser.Secondname, ldapUser.Email
 			uyuniUser.FlushRoles()
 			for _, role := range ldapUser.GetRoles() {
@@ -147,10 +301,11 @@ func (sync *LDAPSync) updateFromLDAPUser(uyuniUser *UyuniUser) {
 
 // GetDeletedUsers returns an array of users that has been deleted from Uyuni.
 // They are both in Uyuni and LDAP, but they are not specified in the LDAP admin-related groups.
+// Only users, managed by the sync, are deleted.
 func (sync *LDAPSync) GetDeletedUsers() []*UyuniUser {
 	var users []*UyuniUser
 	for _, user := range sync.allldapusers {
-		if !sync.in(*user, sync.ldapusers) && sync.in(*user, sync.uyuniusers) {
+		if sync.isSelected(user.Uid) && !sync.in(*user, sync.ldapusers) && sync.in(*user, sync.uyuniusers) && sync.isManaged(user.Uid) {
 			users = append(users, user)
 		}
 	}
@@ -171,11 +326,12 @@ func (sync *LDAPSync) GetNewUsers() []*UyuniUser {
 	return users
 }
 
-// GetOutdatedUsers returns LDAP users that are in the Uyuni, but needs refresh
+// GetOutdatedUsers returns LDAP users that are in the Uyuni, but needs refresh.
+// Only users, managed by the sync, are updated.
 func (sync *LDAPSync) GetOutdatedUsers() []*UyuniUser {
 	var users []*UyuniUser
 	for _, user := range sync.uyuniusers {
-		if !user.IsNew() && user.IsOutdated() {
+		if !user.IsNew() && user.IsOutdated() && sync.isManaged(user.Uid) {
 			users = append(users, user)
 		}
 	}
@@ -184,22 +340,38 @@ func (sync *LDAPSync) GetOutdatedUsers() []*UyuniUser {
 }
 
 // SyncUsers is creating new users in Uyuni by their names and emails.
-func (sync *LDAPSync) SyncUsers() []*UyuniUser {
+// Returns a report of every attempted operation.
+func (sync *LDAPSync) SyncUsers() *SyncReport {
 	Log.Info("Begin user synchronisation between LDAP and Uyuni server")
 
-	failed := make([]*UyuniUser, 0)
+	report := NewSyncReport()
 	newUsers := sync.GetNewUsers()
 	if len(newUsers) > 0 {
 		Log.Debugf("Found %d new users", len(newUsers))
-		for _, user := range newUsers {
-			Log.Debugf("New user: %s", user.Uid)
+		// Roles are reported separately, so the created account is managed even if they fail
+		roleErrs := make([]error, len(newUsers))
+		errs := sync.pool.Run(len(newUsers), func(idx int) error {
+			user := newUsers[idx]
 			_, user.Err = sync.uc.Call("user.create", sync.uc.Session(), user.Uid, "", user.Name, user.Secondname, user.Email, 1)
-
 			if !user.IsValid() {
-				failed = append(failed, user)
-				Log.Debugf("Failed to create user %s due to %s", user.Uid, user.Err.Error())
-			} else {
-				sync.pushUserRolesToUyuni(user)
+				if user.Err == nil {
+					return fmt.Errorf("User data is incomplete")
+				}
+				return user.Err
+			}
+			roleErrs[idx] = sync.pushUserRolesToUyuni(user)
+			return nil
+		})
+
+		for idx, user := range newUsers {
+			Log.Debugf("New user: %s", user.Uid)
+			if errs[idx] != nil {
+				Log.Errorf("Failed to create user %s: %s", user.Uid, errs[idx].Error())
+			}
+			report.Add(user.Uid, ActionCreate, errs[idx])
+			if roleErrs[idx] != nil {
+				Log.Errorf("Failed to set roles of the new user %s: %s", user.Uid, roleErrs[idx].Error())
+				report.Add(user.Uid, ActionUpdate, roleErrs[idx])
 			}
 		}
 	}
@@ -207,67 +379,86 @@ func (sync *LDAPSync) SyncUsers() []*UyuniUser {
 	existingUsers := sync.GetOutdatedUsers()
 	if len(existingUsers) > 0 {
 		Log.Debugf("Updating %d users", len(existingUsers))
-		for _, user := range existingUsers {
+		errs := sync.pool.Run(len(existingUsers), func(idx int) error {
+			if err := sync.pushUserRolesToUyuni(existingUsers[idx]); err != nil {
+				return err
+			}
+			return sync.pushUserAccountDataToUyuni(existingUsers[idx])
+		})
+
+		for idx, user := range existingUsers {
 			Log.Debugf("Update data for user: %s", user.Uid)
-			sync.pushUserRolesToUyuni(user)
-			sync.pushUserAccountDataToUyuni(user)
+			if errs[idx] != nil {
+				Log.Errorf("Failed to update user %s: %s", user.Uid, errs[idx].Error())
+			}
+			report.Add(user.Uid, ActionUpdate, errs[idx])
 		}
 	}
 
 	deletedUsers := sync.GetDeletedUsers()
 	if len(deletedUsers) > 0 {
 		Log.Debugf("Deleting removed %d users", len(deletedUsers))
-		for _, user := range deletedUsers {
+		errs := sync.pool.Run(len(deletedUsers), func(idx int) error {
+			return sync.deleteUser(deletedUsers[idx])
+		})
+
+		for idx, user := range deletedUsers {
 			Log.Debugf("Remove user: %s", user.Uid)
-			sync.deleteUser(user)
+			if errs[idx] != nil {
+				Log.Errorf("Cannot delete users '%s': %s", user.Uid, errs[idx].Error())
+			}
+			report.Add(user.Uid, ActionDelete, errs[idx])
 		}
 	}
 
-	Log.Infof("Added %d new users, updated %d existing users, removed %d users", len(newUsers), len(existingUsers), len(deletedUsers))
+	report.Overrides = sync.Overrides()
+	report.Finish()
+	sync.updateState(report)
+	Log.Infof("Added %d new users, updated %d existing users, removed %d users, %d operations failed",
+		len(newUsers), len(existingUsers), len(deletedUsers), len(report.Failed()))
 	Log.Info("End user synchronisation between LDAP and Uyuni server")
 
-	return failed
+	return report
 }
 
 // Remove user from the Uyuni
-func (sync *LDAPSync) deleteUser(uyuniUser *UyuniUser) {
+func (sync *LDAPSync) deleteUser(uyuniUser *UyuniUser) error {
 	_, err := sync.uc.Call("user.delete", sync.uc.Session(), uyuniUser.Uid)
-	if err != nil {
-		Log.Errorf("Cannot delete users '%s': %s", uyuniUser.Uid, err.Error())
-	}
+	return err
 }
 
 // Push account data to Uyuni
-func (sync *LDAPSync) pushUserAccountDataToUyuni(user *UyuniUser) {
+func (sync *LDAPSync) pushUserAccountDataToUyuni(user *UyuniUser) error {
 	_, err := sync.uc.Call("user.setDetails", sync.uc.Session(), user.Uid, map[string]string{
 		"first_name": user.Name, "last_name": user.Secondname, "email": user.Email})
 	if err != nil {
-		Log.Errorf("Failed to push user account data for %s: %s", user.Uid, err.Error())
-		return
+		return fmt.Errorf("Failed to push user account data: %s", err.Error())
 	}
 
 	_, err = sync.uc.Call("user.usePamAuthentication", sync.uc.Session(), user.Uid, 1)
 	if err != nil {
-		Log.Errorf("Failed to push user authentication settings for %s: %s", user.Uid, err.Error())
-		return
+		return fmt.Errorf("Failed to push user authentication settings: %s", err.Error())
 	}
+
+	return nil
 }
 
 // Sync user roles
-func (sync *LDAPSync) pushUserRolesToUyuni(uyuniUser *UyuniUser) {
+func (sync *LDAPSync) pushUserRolesToUyuni(uyuniUser *UyuniUser) error {
 	// Remove current roles away
 	ret, err := sync.uc.Call("user.listRoles", sync.uc.Session(), uyuniUser.Uid)
 	if err != nil {
-		Log.Errorf("Cannot list roles for user '%s': %s", uyuniUser.Uid, err.Error())
-		return
+		return fmt.Errorf("Cannot list roles: %s", err.Error())
 	}
 
+	errs := make([]string, 0)
 	for _, role := range ret.([]interface{}) {
+		if !sync.serverRoles && IsServerRole(role.(string)) {
+			continue
+		}
 		_, err := sync.uc.Call("user.removeRole", sync.uc.Session(), uyuniUser.Uid, role.(string))
 		if err != nil {
-			Log.Errorf("Cannot remove role '%s': %s", role, err.Error())
-		} else {
-			Log.Debugf("Removed role '%s'", role)
+			errs = append(errs, fmt.Sprintf("cannot remove role '%s': %s", role, err.Error()))
 		}
 	}
 
@@ -275,32 +466,24 @@ func (sync *LDAPSync) pushUserRolesToUyuni(uyuniUser *UyuniUser) {
 	for _, role := range uyuniUser.GetRoles() {
 		_, err := sync.uc.Call("user.addRole", sync.uc.Session(), uyuniUser.Uid, role)
 		if err != nil {
-			Log.Errorf("Cannot add role '%s': %s", role, err.Error())
-		} else {
-			Log.Debugf("Added role '%s'", role)
+			errs = append(errs, fmt.Sprintf("cannot add role '%s': %s", role, err.Error()))
 		}
 	}
-}
 
-// Iterate over possible attribute aliases
-func (sync LDAPSync) getAttributes(entry *ldap.Entry, attr ...string) string {
-	for _, a := range attr {
-		obj := entry.GetAttributeValue(a)
-		if obj != "" {
-			return obj
-		}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
 	}
 
-	return ""
+	return nil
 }
 
 // At least one ignored/frozen user must have org_admin role
-func (sync *LDAPSync) verifyIgnoredUsers() {
+func (sync *LDAPSync) verifyIgnoredUsers() error {
 	valid := false
-	for _, uid := range sync.cr.Config().Directory.Frozen {
+	for _, uid := range sync.frozenUyuni {
 		res, err := sync.uc.Call("user.listRoles", sync.uc.Session(), uid)
 		if err != nil {
-			Log.Errorf("No users has been found with the UID '%s'", uid)
+			Log.Errorf("Unable to get roles of the frozen user '%s': %s", uid, err.Error())
 		} else {
 			for _, role := range res.([]interface{}) {
 				if role.(string) == "org_admin" {
@@ -312,15 +495,85 @@ func (sync *LDAPSync) verifyIgnoredUsers() {
 	}
 End:
 	if !valid {
-		Log.Fatal("In Uyuni server no actual frozen accounts found with the role 'org_admin'. " +
+		return errors.New("In Uyuni server no actual frozen accounts found with the role 'org_admin'. " +
 			"You are risking permanently locking Uyuni server, if you have incorrect LDAP users settings.")
 	}
+
+	return nil
+}
+
+// All mapped roles must be assignable on the Uyuni server. Roles, implied by "org_admin",
+// are taken from the server as well. Server-wide roles are managed only,
+// if the Uyuni user of the sync is a satellite administrator itself.
+func (sync *LDAPSync) verifyMappedRoles() error {
+	sync.serverRoles = false
+	res, err := sync.uc.Call("user.listRoles", sync.uc.Session(), sync.cr.Config().Spacewalk.User)
+	if err != nil {
+		Log.Warnf("Unable to get roles of the Uyuni user '%s': %s", sync.cr.Config().Spacewalk.User, err.Error())
+	} else {
+		for _, role := range res.([]interface{}) {
+			if role.(string) == "satellite_admin" {
+				sync.serverRoles = true
+			}
+		}
+	}
+
+	assignable := make(map[string]bool)
+	res, err = sync.uc.Call("user.listAssignableRoles", sync.uc.Session())
+	if err != nil {
+		return fmt.Errorf("Unable to get assignable roles from Uyuni to verify the mapped roles: %s", err.Error())
+	}
+	roles := make([]string, 0)
+	for _, role := range res.([]interface{}) {
+		assignable[role.(string)] = true
+		roles = append(roles, role.(string))
+	}
+	sync.orgAdminRoles = OrgAdminImpliedRoles(roles)
+
+	for _, searchConfig := range sync.roleConfigs {
+		for dn, roles := range *searchConfig.config {
+			for _, role := range roles {
+				if IsServerRole(role) {
+					if !sync.serverRoles {
+						Log.Warnf("Role '%s', mapped to '%s' in %s, is ignored: Uyuni user '%s' is not a satellite administrator",
+							role, dn, searchConfig.name, sync.cr.Config().Spacewalk.User)
+					}
+				} else if len(assignable) > 0 && !assignable[strings.ToLower(role)] {
+					return fmt.Errorf("Role '%s', mapped to '%s' in %s, cannot be assigned on Uyuni server", role, dn, searchConfig.name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Create an empty user, expanding "org_admin" to the roles, assignable on the server
+func (sync *LDAPSync) newUser() *UyuniUser {
+	return NewUyuniUser().SetImpliedRoles(sync.orgAdminRoles)
+}
+
+// Get the roles, the sync is able to grant. Server-wide roles are left out,
+// unless the Uyuni user of the sync is a satellite administrator.
+func (sync *LDAPSync) grantableRoles(roles []string) []string {
+	if sync.serverRoles {
+		return roles
+	}
+
+	grantable := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !IsServerRole(role) {
+			grantable = append(grantable, role)
+		}
+	}
+	return grantable
 }
 
 // Refresh what users are new and what needs update
 func (sync *LDAPSync) refreshUyuniUsersStatus() []*UyuniUser {
 	var newusers []*UyuniUser
 	for _, user := range sync.ldapusers {
+		sync.applyOverride(user)
 		user.new = !sync.in(*user, sync.uyuniusers)
 		if !user.IsNew() {
 			isSame, err := sync.sameAsIn(user, sync.uyuniusers)
@@ -332,8 +585,16 @@ func (sync *LDAPSync) refreshUyuniUsersStatus() []*UyuniUser {
 		}
 
 		for _, uUuser := range sync.uyuniusers {
-			if uUuser.Uid == user.Uid {
+			if sync.sameLogin(uUuser.Uid, user.Uid) {
 				uUuser.outdated = user.outdated
+				uUuser.accountchanged = user.accountchanged
+				uUuser.roleschanged = user.roleschanged
+				uUuser.changes = user.changes
+				uUuser.rolesadded = user.rolesadded
+				uUuser.rolesremoved = user.rolesremoved
+				uUuser.denials = user.denials
+				uUuser.override = user.override
+				uUuser.Dn = user.Dn
 				uUuser.Name = user.Name
 				uUuser.Secondname = user.Secondname
 				uUuser.Email = user.Email
@@ -348,80 +609,121 @@ func (sync *LDAPSync) refreshUyuniUsersStatus() []*UyuniUser {
 }
 
 // Get all existing users in Uyuni.
-func (sync *LDAPSync) refreshExistingUyuniUsers() []*UyuniUser {
+func (sync *LDAPSync) refreshExistingUyuniUsers() ([]*UyuniUser, error) {
 	sync.uyuniusers = nil
 	res, err := sync.uc.Call("user.listUsers", sync.uc.Session())
 	if err != nil {
-		Log.Fatal(err)
+		return nil, fmt.Errorf("Unable to get Uyuni users: %s", err.Error())
 	}
+
+	logins := make([]string, 0)
 	for _, usrdata := range res.([]interface{}) {
 		uid := usrdata.(map[string]interface{})["login"].(string)
-		if funk.Contains(sync.cr.Config().Directory.Frozen, uid) {
-			continue
+		if !sync.isFrozen(uid) && sync.isSelected(uid) {
+			logins = append(logins, uid)
 		}
+	}
 
-		user := NewUyuniUser()
-		user.Uid = uid
+	users := make([]*UyuniUser, len(logins))
+	errs := sync.pool.Run(len(logins), func(idx int) error {
+		var err error
+		users[idx], err = sync.getUyuniUser(logins[idx])
+		return err
+	})
 
-		res, err = sync.uc.Call("user.getDetails", sync.uc.Session(), user.Uid)
+	for idx, err := range errs {
 		if err != nil {
-			Log.Fatal(err)
+			return nil, fmt.Errorf("Unable to get data of Uyuni user '%s': %s", logins[idx], err.Error())
 		}
-		userDetails := res.(map[string]interface{})
+	}
+	sync.uyuniusers = users
+
+	return sync.uyuniusers, nil
+}
 
-		user.Email = userDetails["email"].(string)
-		user.Name = userDetails["first_name"].(string)
-		user.Secondname = userDetails["last_name"].(string)
+// Get user account data and roles from Uyuni
+func (sync *LDAPSync) getUyuniUser(uid string) (*UyuniUser, error) {
+	user := sync.newUser()
+	user.Uid = uid
 
-		// Get user roles
+	res, err := sync.uc.Call("user.getDetails", sync.uc.Session(), user.Uid)
+	if err != nil {
+		return nil, err
+	}
+	userDetails := res.(map[string]interface{})
+
+	user.Email = userDetails["email"].(string)
+	user.Name = userDetails["first_name"].(string)
+	user.Secondname = userDetails["last_name"].(string)
+
+	// Get user roles, unless they were already read for the frozen roles
+	roles, ext := sync.uyuniRoles[user.Uid]
+	if !ext {
 		res, err = sync.uc.Call("user.listRoles", sync.uc.Session(), user.Uid)
 		if err != nil {
-			Log.Fatal(err)
+			return nil, err
 		}
 
+		roles = make([]string, 0)
 		for _, roleItf := range res.([]interface{}) {
-			user.AddRoles(roleItf.(string))
+			roles = append(roles, roleItf.(string))
 		}
-
-		sync.uyuniusers = append(sync.uyuniusers, user)
 	}
-	return sync.uyuniusers
+	user.AddRoles(sync.grantableRoles(roles)...)
+
+	return user, nil
 }
 
 // Get an attribute name for DN.
 // This allows to substitute remapped fields from the configuration, returning
-// new remapped name, or keep the original one.
-func (sync *LDAPSync) getAttributeNameFor(attr string) string {
-	if fieldmap, ext := sync.cr.Config().Directory.Attrmap[sync.cr.Config().Directory.Allusers]; ext {
-		nAttr, ext := fieldmap[attr]
-		if ext {
-			attr = nAttr
+// new remapped name, or keep the original one. Only the map of the longest base DN,
+// the user DN falls under, is used. Attributes it does not remap fall back to the default map.
+func (sync *LDAPSync) getAttributeNameFor(dn string, attr string) string {
+	depth := -1
+	var fieldmap map[string]string
+	for base, fm := range sync.cr.Config().Directory.Attrmap {
+		if base == AttrmapDefault {
+			continue
+		}
+		if d := DNDepthUnder(dn, base); d > depth {
+			depth, fieldmap = d, fm
 		}
 	}
 
+	if nAttr, ext := fieldmap[attr]; ext {
+		return nAttr
+	}
+	if nAttr, ext := sync.cr.Config().Directory.Attrmap[AttrmapDefault][attr]; ext {
+		return nAttr
+	}
+
 	return attr
 }
 
 // Create a new user from a given DN
 func (sync *LDAPSync) newUserFromDN(dn string) *UyuniUser {
-	user := NewUyuniUser()
+	user := sync.newUser()
+	// Immutable IDs are mostly operational attributes, returned only if requested by name
 	request := ldap.NewSearchRequest(dn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(objectClass=*)", []string{}, nil)
+		"(objectClass=*)", append([]string{"*"}, sync.cr.Config().Directory.Idattributes...), nil)
 
 	entries := sync.lc.Search(request).Entries
 	if len(entries) == 1 {
 		entry := entries[0]
 		user.Dn = entry.DN
-		user.Uid = entry.GetAttributeValue(sync.getAttributeNameFor("uid"))
-		user.Email = entry.GetAttributeValue(sync.getAttributeNameFor("mail"))
+		user.Id = sync.immutableID(entry)
+		if user.Id == "" && len(sync.cr.Config().Directory.Idattributes) > 0 {
+			Log.Warnf("User entry '%s' has none of the ID attributes %s, renames of it cannot be recognised",
+				entry.DN, strings.Join(sync.cr.Config().Directory.Idattributes, ", "))
+		}
+		user.Uid = sync.foldLogin(sync.transform(FieldUid, entry.GetAttributeValues(sync.getAttributeNameFor(entry.DN, "uid"))))
+		user.Email = sync.transform(FieldEmail, entry.GetAttributeValues(sync.getAttributeNameFor(entry.DN, "mail")))
 
-		cn := strings.Split(entry.GetAttributeValue("cn"), " ")
-		if len(cn) == 2 {
-			user.Name, user.Secondname = cn[0], cn[1]
-		} else {
-			user.Name = sync.getAttributes(entry, sync.getAttributeNameFor("name"), sync.getAttributeNameFor("givenName"))
-			user.Secondname = entry.GetAttributeValue(sync.getAttributeNameFor("sn"))
+		get := func(attr string) string {
+			return entry.GetAttributeValue(sync.getAttributeNameFor(entry.DN, attr))
 		}
+		user.Name = sync.transform(FieldFirstname, []string{sync.firstname.Expand(get)})
+		user.Secondname = sync.transform(FieldLastname, []string{sync.lastname.Expand(get)})
 	} else {
 		Log.Errorf("DN '%s' matches more or less than one distinct user", dn)
 	}
@@ -429,15 +731,57 @@ func (sync *LDAPSync) newUserFromDN(dn string) *UyuniUser {
 	return user
 }
 
+// Get search requests for all user bases. An additional filter narrows down each of them.
+func (sync *LDAPSync) userSearchRequests(filter string) []*ldap.SearchRequest {
+	requests := make([]*ldap.SearchRequest, 0)
+	for _, base := range sync.cr.Config().Directory.Userbases {
+		scope := ldap.ScopeWholeSubtree
+		switch base.Scope {
+		case ScopeOne:
+			scope = ldap.ScopeSingleLevel
+		case ScopeBase:
+			scope = ldap.ScopeBaseObject
+		}
+
+		baseFilter := base.Filter
+		if filter != "" {
+			baseFilter = fmt.Sprintf("(&%s%s)", base.Filter, filter)
+		}
+		requests = append(requests, ldap.NewSearchRequest(base.Dn, scope, ldap.NeverDerefAliases, 0, 0, false,
+			baseFilter, []string{}, nil))
+	}
+
+	return requests
+}
+
+// Get distinct DNs of the users across all user bases, matching an optional filter.
+// Bases may overlap, so the same entry is returned only once.
+func (sync *LDAPSync) searchUserDNs(filter string) []string {
+	dns := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, request := range sync.userSearchRequests(filter) {
+		for _, entry := range sync.lc.Search(request).Entries {
+			if key := CanonicalDN(entry.DN); !seen[key] {
+				seen[key] = true
+				dns = append(dns, entry.DN)
+			}
+		}
+	}
+
+	return dns
+}
+
+// Apply the configured transforms of the Uyuni field to the attribute values.
+// Without transforms the first value is taken.
+func (sync *LDAPSync) transform(field string, values []string) string {
+	return sync.transforms[field].Apply(values)
+}
+
 // Get all users from LDAP, regardless are they are meant to be in the Uyuni
 func (sync *LDAPSync) refreshAllLDAPUsers() []*UyuniUser {
 	sync.allldapusers = nil
-	request := ldap.NewSearchRequest(sync.cr.Config().Directory.Allusers,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		"(objectClass=organizationalPerson)", []string{}, nil)
-
-	for _, entry := range sync.lc.Search(request).Entries {
-		sync.allldapusers = append(sync.allldapusers, sync.newUserFromDN(entry.DN))
+	for _, dn := range sync.searchUserDNs("") {
+		sync.allldapusers = append(sync.allldapusers, sync.newUserFromDN(dn))
 	}
 
 	return sync.allldapusers
@@ -446,25 +790,32 @@ func (sync *LDAPSync) refreshAllLDAPUsers() []*UyuniUser {
 // Get existing LDAP users, based on the groups mapping
 func (sync *LDAPSync) refreshStagedLDAPUsers() []*UyuniUser {
 	sync.ldapusers = nil
-	udns := make(map[string]bool)
+	udns := make(map[string]string)
+
+	// Temporary mappings stage their members regardless of the window, only their roles are time-bounded.
+	// Otherwise the account would be deleted after the window, not only its roles removed.
+	temporary := make(map[string][]string)
+	for _, tm := range sync.cr.Config().Directory.Temporary {
+		temporary[tm.Dn] = tm.Roles
+	}
 
 	// Get all *distinct* user DNs from the "member" attiribute across all the groups
-	for _, roleset := range []map[string][]string{sync.cr.Config().Directory.Groups, sync.cr.Config().Directory.Roles} {
+	for _, roleset := range []map[string][]string{sync.cr.Config().Directory.Groups, sync.cr.Config().Directory.Roles, temporary} {
 		for gdn := range roleset {
 			request := ldap.NewSearchRequest(gdn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 				"(objectClass=*)", []string{}, nil)
 			for _, entry := range sync.lc.Search(request).Entries {
 				for _, udn := range append(entry.GetAttributeValues("member"), entry.GetAttributeValues("roleOccupant")...) {
-					udns[udn] = true
+					udns[CanonicalDN(udn)] = udn
 				}
 			}
 		}
 	}
 
 	// Collect users data
-	for udn := range udns {
+	for _, udn := range udns {
 		user := sync.newUserFromDN(udn)
-		if user.Uid != "" && !funk.Contains(sync.cr.Config().Directory.Frozen, user.Uid) {
+		if user.Uid != "" && !sync.isFrozen(user.Uid) && sync.isSelected(user.Uid) {
 			sync.updateLDAPUserRoles(user)
 			sync.ldapusers = append(sync.ldapusers, user)
 		}
@@ -473,23 +824,58 @@ func (sync *LDAPSync) refreshStagedLDAPUsers() []*UyuniUser {
 	return sync.ldapusers
 }
 
-// Merge roles by attributes
-func (sync *LDAPSync) mergeRolesByAttributes(dn string, user *UyuniUser, filter string, attribute string, uyuniRoles []string) {
-	req := ldap.NewSearchRequest(dn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, filter, []string{}, nil)
+// Get the DNs from the mapping down to the entry under it, including every nested level in between
+func nestingPath(dn string, entryDn string) []string {
+	path := make([]string, 0)
+	for level := entryDn; level != "" && !EqualDN(level, dn) && DNDepthUnder(level, dn) >= 0; level = ParentDN(level) {
+		path = append([]string{level}, path...)
+	}
+
+	return append([]string{dn}, path...)
+}
+
+// Get nesting paths of the entries under the DN, the user is a member of
+func (sync *LDAPSync) membershipPaths(dn string, user *UyuniUser, searchConfig *SearchConfig) [][]string {
+	paths := make([][]string, 0)
+	req := ldap.NewSearchRequest(dn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		searchConfig.filter, []string{}, nil)
 	for _, entry := range sync.lc.Search(req).Entries {
-		for _, roleDn := range entry.GetAttributeValues(attribute) {
-			if roleDn == user.Dn {
-				user.AddRoles(uyuniRoles...)
+		for _, roleDn := range entry.GetAttributeValues(searchConfig.attribute) {
+			if EqualDN(roleDn, user.Dn) {
+				paths = append(paths, nestingPath(dn, entry.DN))
 			}
 		}
 	}
+
+	return paths
+}
+
+// Merge roles by attributes
+func (sync *LDAPSync) mergeRolesByAttributes(dn string, user *UyuniUser, searchConfig *SearchConfig, uyuniRoles []string) {
+	for _, path := range sync.membershipPaths(dn, user, searchConfig) {
+		for _, role := range uyuniRoles {
+			user.grants = append(user.grants, &RoleGrant{Role: role, Source: searchConfig.name, Mapping: dn, Path: path})
+		}
+		user.AddRoles(uyuniRoles...)
+	}
 }
 
 // Get LDAP organizationalRole based on configuration
 func (sync *LDAPSync) updateLDAPUserRoles(user *UyuniUser) {
+	user.grants = nil
+	user.override = nil
 	for _, searchConfig := range sync.roleConfigs {
-		for dn, uyuniRoles := range *searchConfig.config {
-			sync.mergeRolesByAttributes(dn, user, searchConfig.filter, searchConfig.attribute, uyuniRoles)
+		dns := make([]string, 0)
+		for dn := range *searchConfig.config {
+			dns = append(dns, dn)
+		}
+		sort.Strings(dns)
+
+		for _, dn := range dns {
+			sync.mergeRolesByAttributes(dn, user, searchConfig, sync.grantableRoles((*searchConfig.config)[dn]))
 		}
 	}
+
+	sync.mergeTemporaryRoles(user)
+	sync.applyDenials(user)
 }
@@ -0,0 +1,194 @@
+package ldapsync
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap"
+)
+
+// LDIFDirectory is an offline directory, loaded from an LDIF file
+type LDIFDirectory struct {
+	*MemoryDirectory
+	path string
+}
+
+// NewLDIFDirectory is a constructor for the LDIFDirectory object
+func NewLDIFDirectory(path string) *LDIFDirectory {
+	ld := new(LDIFDirectory)
+	ld.MemoryDirectory = NewMemoryDirectory()
+	ld.path = path
+
+	return ld
+}
+
+// Connect loads the LDIF file
+func (ld *LDIFDirectory) Connect() {
+	if len(ld.Entries()) > 0 {
+		return
+	}
+
+	fh, err := os.Open(ld.path)
+	if err != nil {
+		Log.Fatal(err)
+	}
+	defer fh.Close()
+
+	entries, err := ParseLDIF(fh)
+	if err != nil {
+		Log.Fatalf("Unable to load LDIF file '%s': %s", ld.path, err.Error())
+	}
+	for _, entry := range entries {
+		ld.AddEntry(entry)
+	}
+
+	Log.Debugf("Loaded %d entries from %s", len(entries), ld.path)
+}
+
+// ParseLDIF parses LDIF content records (RFC 2849) into entries.
+// Change records are accepted only if they are additions.
+func ParseLDIF(r io.Reader) ([]*ldap.Entry, error) {
+	entries := make([]*ldap.Entry, 0)
+	lines := make([]string, 0)
+	lineno := 0
+
+	flush := func() error {
+		if len(lines) > 0 {
+			entry, err := parseLDIFRecord(lines)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", lineno, err.Error())
+			}
+			if entry != nil {
+				entries = append(entries, entry)
+			}
+		}
+		lines = lines[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case line == "":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, " "):
+			// Folded line continues the previous one
+			if len(lines) == 0 {
+				return nil, fmt.Errorf("line %d: continuation without a preceding line", lineno)
+			}
+			lines[len(lines)-1] += line[1:]
+		default:
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Parse a single LDIF record into an entry
+func parseLDIFRecord(lines []string) (*ldap.Entry, error) {
+	var dn string
+	attrs := make(map[string][]string)
+	names := make([]string, 0)
+
+	for _, line := range lines {
+		attr, value, err := parseLDIFLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch strings.ToLower(attr) {
+		case "version":
+			if dn == "" {
+				continue
+			}
+		case "dn":
+			dn = value
+			continue
+		case "changetype":
+			if strings.ToLower(value) != "add" {
+				return nil, fmt.Errorf("unsupported change type '%s' for '%s'", value, dn)
+			}
+			continue
+		case "-":
+			continue
+		}
+
+		if dn == "" {
+			return nil, fmt.Errorf("record has no DN")
+		}
+		if _, ext := attrs[attr]; !ext {
+			names = append(names, attr)
+		}
+		attrs[attr] = append(attrs[attr], value)
+	}
+
+	if dn == "" {
+		return nil, nil
+	}
+
+	entry := &ldap.Entry{DN: dn}
+	for _, name := range names {
+		entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute(name, attrs[name]))
+	}
+
+	return entry, nil
+}
+
+// Parse a single "attr: value", "attr:: base64" or "attr:< url" line
+func parseLDIFLine(line string) (string, string, error) {
+	if line == "-" {
+		return line, "", nil
+	}
+
+	idx := strings.Index(line, ":")
+	if idx < 1 {
+		return "", "", fmt.Errorf("invalid line '%s'", line)
+	}
+	attr, value := line[:idx], line[idx+1:]
+
+	// Attribute options, like ";binary" are not relevant here
+	if opt := strings.Index(attr, ";"); opt > 0 {
+		attr = attr[:opt]
+	}
+
+	switch {
+	case strings.HasPrefix(value, ":"):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 value of '%s': %s", attr, err.Error())
+		}
+		return attr, string(data), nil
+	case strings.HasPrefix(value, "<"):
+		ref, err := url.Parse(strings.TrimSpace(value[1:]))
+		if err != nil || ref.Scheme != "file" {
+			return "", "", fmt.Errorf("only file:// URLs are supported for '%s'", attr)
+		}
+		data, err := ioutil.ReadFile(ref.Path)
+		if err != nil {
+			return "", "", err
+		}
+		return attr, string(data), nil
+	}
+
+	return attr, strings.TrimLeft(value, " "), nil
+}
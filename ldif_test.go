@@ -0,0 +1,150 @@
+package ldapsync
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap"
+)
+
+func TestParseLDIF(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		ldif  string
+		want  map[string]map[string][]string // Attributes by DN
+		fails bool
+	}{
+		{
+			name: "plain records with version and comments",
+			ldif: `version: 1
+# The first user
+dn: uid=jdoe,ou=users,dc=example,dc=com
+objectClass: inetOrgPerson
+uid: jdoe
+mail: john@example.com
+
+# The second user
+
+dn: uid=jroe,ou=users,dc=example,dc=com
+uid: jroe
+`,
+			want: map[string]map[string][]string{
+				"uid=jdoe,ou=users,dc=example,dc=com": {"objectClass": {"inetOrgPerson"}, "uid": {"jdoe"}, "mail": {"john@example.com"}},
+				"uid=jroe,ou=users,dc=example,dc=com": {"uid": {"jroe"}},
+			},
+		},
+		{
+			name: "folded lines",
+			ldif: "dn: cn=admins,ou=groups,\r\n dc=example,dc=com\r\ndescription: Organisation\r\n  administrators\r\nmember: uid=jdoe,ou=users,dc=exa\r\n mple,dc=com\r\n",
+			want: map[string]map[string][]string{
+				"cn=admins,ou=groups,dc=example,dc=com": {
+					"description": {"Organisation administrators"},
+					"member":      {"uid=jdoe,ou=users,dc=example,dc=com"},
+				},
+			},
+		},
+		{
+			name: "base64 values and attribute options",
+			ldif: `dn:: dWlkPWrDtnJnLG91PXVzZXJzLGRjPWV4YW1wbGUsZGM9Y29t
+cn:: SsO2cmcgTcO8bGxlcg==
+sn;lang-de: Müller
+`,
+			want: map[string]map[string][]string{
+				"uid=jörg,ou=users,dc=example,dc=com": {"cn": {"Jörg Müller"}, "sn": {"Müller"}},
+			},
+		},
+		{
+			name: "multiple values keep their order",
+			ldif: `dn: cn=admins,ou=groups,dc=example,dc=com
+member: uid=b,ou=users,dc=example,dc=com
+cn: admins
+member: uid=a,ou=users,dc=example,dc=com
+`,
+			want: map[string]map[string][]string{
+				"cn=admins,ou=groups,dc=example,dc=com": {
+					"member": {"uid=b,ou=users,dc=example,dc=com", "uid=a,ou=users,dc=example,dc=com"},
+					"cn":     {"admins"},
+				},
+			},
+		},
+		{
+			name: "add change records",
+			ldif: `dn: uid=jdoe,ou=users,dc=example,dc=com
+changetype: add
+uid: jdoe
+`,
+			want: map[string]map[string][]string{
+				"uid=jdoe,ou=users,dc=example,dc=com": {"uid": {"jdoe"}},
+			},
+		},
+		{
+			name: "modify change records",
+			ldif: `dn: uid=jdoe,ou=users,dc=example,dc=com
+changetype: modify
+replace: mail
+mail: john@example.com
+-
+`,
+			fails: true,
+		},
+		{
+			name: "delete change records",
+			ldif: `dn: uid=jdoe,ou=users,dc=example,dc=com
+changetype: delete
+`,
+			fails: true,
+		},
+		{
+			name:  "invalid base64",
+			ldif:  "dn: uid=jdoe,ou=users,dc=example,dc=com\ncn:: not base64!\n",
+			fails: true,
+		},
+		{
+			name:  "attributes without DN",
+			ldif:  "uid: jdoe\n",
+			fails: true,
+		},
+		{
+			name:  "continuation without a line",
+			ldif:  " uid: jdoe\n",
+			fails: true,
+		},
+		{
+			name:  "line without a colon",
+			ldif:  "dn: uid=jdoe,ou=users,dc=example,dc=com\nuid\n",
+			fails: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := ParseLDIF(strings.NewReader(tc.ldif))
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("Expected an error, got %d entries", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]map[string][]string)
+			for _, entry := range entries {
+				got[entry.DN] = ldifTestAttributes(entry)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+// Get the attributes of the entry as a map
+func ldifTestAttributes(entry *ldap.Entry) map[string][]string {
+	attrs := make(map[string][]string)
+	for _, attr := range entry.Attributes {
+		attrs[attr.Name] = attr.Values
+	}
+
+	return attrs
+}
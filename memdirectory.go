@@ -0,0 +1,211 @@
+package ldapsync
+
+import (
+	"strings"
+
+	"github.com/go-ldap/ldap"
+	"gopkg.in/asn1-ber.v1"
+)
+
+// DirectorySource answers LDAP searches, either from the live server or from an offline copy
+type DirectorySource interface {
+	Connect()
+	Disconnect()
+	Search(request *ldap.SearchRequest) *ldap.SearchResult
+}
+
+// MemoryDirectory is an in-memory directory, answering LDAP searches on its entries
+type MemoryDirectory struct {
+	entries []*ldap.Entry
+	index   map[string]int
+}
+
+// NewMemoryDirectory is a constructor for the MemoryDirectory object
+func NewMemoryDirectory() *MemoryDirectory {
+	md := new(MemoryDirectory)
+	md.entries = make([]*ldap.Entry, 0)
+	md.index = make(map[string]int)
+
+	return md
+}
+
+// NewPersonEntry creates an entry of the person, as the sync expects it
+func NewPersonEntry(dn string, uid string, name string, secondname string, email string) *ldap.Entry {
+	attrs := map[string][]string{
+		"objectClass": {"top", "person", "organizationalPerson", "inetOrgPerson"},
+		"uid":         {uid},
+		"cn":          {strings.TrimSpace(name + " " + secondname)},
+	}
+	for attr, value := range map[string]string{"givenName": name, "sn": secondname, "mail": email} {
+		if value != "" {
+			attrs[attr] = []string{value}
+		}
+	}
+
+	return ldap.NewEntry(dn, attrs)
+}
+
+// NewGroupEntry creates a groupOfNames entry with the given member DNs
+func NewGroupEntry(dn string, cn string, members []string) *ldap.Entry {
+	return ldap.NewEntry(dn, map[string][]string{"objectClass": {"top", "groupOfNames"}, "cn": {cn}, "member": members})
+}
+
+// Connect does nothing, all the entries are already in the memory
+func (md *MemoryDirectory) Connect() {}
+
+// Disconnect does nothing, all the entries are kept in the memory
+func (md *MemoryDirectory) Disconnect() {}
+
+// AddEntry adds an entry or replaces the existing one with the same DN
+func (md *MemoryDirectory) AddEntry(entry *ldap.Entry) *MemoryDirectory {
+	key := CanonicalDN(entry.DN)
+	if idx, ext := md.index[key]; ext {
+		md.entries[idx] = entry
+		return md
+	}
+	md.index[key] = len(md.entries)
+	md.entries = append(md.entries, entry)
+
+	return md
+}
+
+// Reset replaces all entries of the directory
+func (md *MemoryDirectory) Reset(entries []*ldap.Entry) *MemoryDirectory {
+	md.entries = make([]*ldap.Entry, 0, len(entries))
+	md.index = make(map[string]int)
+	for _, entry := range entries {
+		md.AddEntry(entry)
+	}
+
+	return md
+}
+
+// Entries returns all entries of the directory
+func (md *MemoryDirectory) Entries() []*ldap.Entry {
+	return md.entries
+}
+
+// Search the entries by the request, as the LDAP server would do
+func (md *MemoryDirectory) Search(request *ldap.SearchRequest) *ldap.SearchResult {
+	res := &ldap.SearchResult{Entries: make([]*ldap.Entry, 0)}
+
+	filter, err := ldap.CompileFilter(request.Filter)
+	if err != nil {
+		Log.Fatal(err)
+	}
+
+	// DNs are matched case-insensitively, as most of the LDAP servers do
+	base, err := ldap.ParseDN(strings.ToLower(request.BaseDN))
+	if err != nil {
+		Log.Fatal(err)
+	}
+
+	for _, entry := range md.entries {
+		dn, err := ldap.ParseDN(strings.ToLower(entry.DN))
+		if err != nil {
+			Log.Errorf("Skipping entry with invalid DN '%s': %s", entry.DN, err.Error())
+			continue
+		}
+
+		inScope := false
+		switch request.Scope {
+		case ldap.ScopeBaseObject:
+			inScope = base.Equal(dn)
+		case ldap.ScopeSingleLevel:
+			inScope = base.AncestorOf(dn) && len(dn.RDNs) == len(base.RDNs)+1
+		default:
+			inScope = base.Equal(dn) || base.AncestorOf(dn)
+		}
+
+		if inScope && md.match(entry, filter) {
+			res.Entries = append(res.Entries, entry)
+		}
+	}
+
+	return res
+}
+
+// Get all values of the attribute, the name is case-insensitive
+func (md *MemoryDirectory) values(entry *ldap.Entry, attr string) []string {
+	for _, a := range entry.Attributes {
+		if strings.EqualFold(a.Name, attr) {
+			return a.Values
+		}
+	}
+
+	return nil
+}
+
+// Match an entry against the compiled filter
+func (md *MemoryDirectory) match(entry *ldap.Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !md.match(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if md.match(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !md.match(entry, filter.Children[0])
+	case ldap.FilterPresent:
+		return len(md.values(entry, ber.DecodeString(filter.Data.Bytes()))) > 0
+	case ldap.FilterEqualityMatch, ldap.FilterApproxMatch, ldap.FilterGreaterOrEqual, ldap.FilterLessOrEqual:
+		expected := strings.ToLower(ber.DecodeString(filter.Children[1].Data.Bytes()))
+		for _, value := range md.values(entry, ber.DecodeString(filter.Children[0].Data.Bytes())) {
+			value = strings.ToLower(value)
+			if (filter.Tag == ldap.FilterGreaterOrEqual && value >= expected) ||
+				(filter.Tag == ldap.FilterLessOrEqual && value <= expected) || value == expected {
+				return true
+			}
+			// DN values, like "member", are matched in their canonical form
+			if filter.Tag == ldap.FilterEqualityMatch && strings.Contains(expected, "=") && EqualDN(value, expected) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterSubstrings:
+		for _, value := range md.values(entry, ber.DecodeString(filter.Children[0].Data.Bytes())) {
+			if md.matchSubstrings(strings.ToLower(value), filter.Children[1].Children) {
+				return true
+			}
+		}
+		return false
+	}
+
+	Log.Warnf("Unsupported filter '%s' for the offline directory", ldap.FilterMap[uint64(filter.Tag)])
+	return false
+}
+
+// Match a value against initial, any and final substrings
+func (md *MemoryDirectory) matchSubstrings(value string, substrings []*ber.Packet) bool {
+	for _, substring := range substrings {
+		part := strings.ToLower(ber.DecodeString(substring.Data.Bytes()))
+		switch substring.Tag {
+		case ldap.FilterSubstringsInitial:
+			if !strings.HasPrefix(value, part) {
+				return false
+			}
+			value = value[len(part):]
+		case ldap.FilterSubstringsAny:
+			idx := strings.Index(value, part)
+			if idx < 0 {
+				return false
+			}
+			value = value[idx+len(part):]
+		case ldap.FilterSubstringsFinal:
+			if !strings.HasSuffix(value, part) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package ldapsync
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/go-ldap/ldap"
+)
+
+// Directory of two users and a group, with the DNs in a mixed case and spacing
+func newTestMemoryDirectory() *MemoryDirectory {
+	return NewMemoryDirectory().
+		AddEntry(ldap.NewEntry("dc=example,dc=com", map[string][]string{"objectClass": {"domain"}, "dc": {"example"}})).
+		AddEntry(ldap.NewEntry("ou=Users,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"Users"}})).
+		AddEntry(ldap.NewEntry("ou=Groups,dc=example,dc=com", map[string][]string{"objectClass": {"organizationalUnit"}, "ou": {"Groups"}})).
+		AddEntry(NewPersonEntry("uid=jdoe,ou=Users,dc=example,dc=com", "jdoe", "John", "Doe", "John.Doe@Example.com")).
+		AddEntry(NewPersonEntry("uid=jroe,ou=Users, dc=Example, dc=Com", "jroe", "Jane", "Roe", "")).
+		AddEntry(NewGroupEntry("cn=Admins,ou=Groups,dc=example,dc=com", "Admins", []string{"uid=JDoe,ou=users,dc=example,dc=com"}))
+}
+
+func TestMemoryDirectorySearch(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		base   string
+		scope  int
+		filter string
+		want   []string
+	}{
+		// Scopes
+		{"base scope", "ou=users,dc=example,dc=com", ldap.ScopeBaseObject, "(objectClass=*)",
+			[]string{"ou=Users,dc=example,dc=com"}},
+		{"one-level scope", "dc=example,dc=com", ldap.ScopeSingleLevel, "(objectClass=*)",
+			[]string{"ou=Groups,dc=example,dc=com", "ou=Users,dc=example,dc=com"}},
+		{"subtree scope", "OU=Users,DC=Example,DC=Com", ldap.ScopeWholeSubtree, "(objectClass=*)",
+			[]string{"ou=Users,dc=example,dc=com", "uid=jdoe,ou=Users,dc=example,dc=com", "uid=jroe,ou=Users, dc=Example, dc=Com"}},
+		{"base with spaces", "ou=Users, dc=example, dc=com", ldap.ScopeSingleLevel, "(objectClass=*)",
+			[]string{"uid=jdoe,ou=Users,dc=example,dc=com", "uid=jroe,ou=Users, dc=Example, dc=Com"}},
+		{"base outside of the directory", "dc=example,dc=org", ldap.ScopeWholeSubtree, "(objectClass=*)", []string{}},
+
+		// Filters
+		{"present", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(mail=*)",
+			[]string{"uid=jdoe,ou=Users,dc=example,dc=com"}},
+		{"equality ignores case of names and values", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(MAIL=john.doe@example.COM)",
+			[]string{"uid=jdoe,ou=Users,dc=example,dc=com"}},
+		{"equality of DN values", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(member=uid=jdoe, ou=Users, dc=example, dc=com)",
+			[]string{"cn=Admins,ou=Groups,dc=example,dc=com"}},
+		{"initial substring", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(uid=J*)",
+			[]string{"uid=jdoe,ou=Users,dc=example,dc=com", "uid=jroe,ou=Users, dc=Example, dc=Com"}},
+		{"any and final substrings", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(cn=*n*ROE)",
+			[]string{"uid=jroe,ou=Users, dc=Example, dc=Com"}},
+		{"substring in order", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(cn=*doe*john*)", []string{}},
+		{"and", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(&(objectClass=inetOrgPerson)(sn=Roe))",
+			[]string{"uid=jroe,ou=Users, dc=Example, dc=Com"}},
+		{"or", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(|(uid=jdoe)(cn=admins))",
+			[]string{"cn=Admins,ou=Groups,dc=example,dc=com", "uid=jdoe,ou=Users,dc=example,dc=com"}},
+		{"not", "ou=users,dc=example,dc=com", ldap.ScopeSingleLevel, "(!(uid=jdoe))",
+			[]string{"uid=jroe,ou=Users, dc=Example, dc=Com"}},
+		{"missing attribute", "dc=example,dc=com", ldap.ScopeWholeSubtree, "(title=*)", []string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := ldap.NewSearchRequest(tc.base, tc.scope, ldap.NeverDerefAliases, 0, 0, false, tc.filter, []string{}, nil)
+			got := make([]string, 0)
+			for _, entry := range newTestMemoryDirectory().Search(req).Entries {
+				got = append(got, entry.DN)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMemoryDirectoryAddEntry(t *testing.T) {
+	md := newTestMemoryDirectory()
+	md.AddEntry(NewPersonEntry("UID=JDoe, OU=Users, DC=Example, DC=Com", "jdoe", "Johnny", "Doe", ""))
+
+	if len(md.Entries()) != 6 {
+		t.Fatalf("Expected the entry with the same canonical DN to be replaced, got %d entries", len(md.Entries()))
+	}
+
+	req := ldap.NewSearchRequest("uid=jdoe,ou=users,dc=example,dc=com", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{}, nil)
+	if entries := md.Search(req).Entries; len(entries) != 1 || entries[0].GetAttributeValue("givenName") != "Johnny" {
+		t.Errorf("Expected the replaced entry to be found")
+	}
+}
@@ -0,0 +1,113 @@
+package ldapsync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Default name templates, preferring explicit attributes over splitting "cn"
+const (
+	DefaultFirstnameTemplate = "{givenName|name|cn.first}"
+	DefaultLastnameTemplate  = "{sn|cn.last}"
+)
+
+// A part of the template, either a literal text or the alternatives of the placeholder
+type nameTemplatePart struct {
+	literal      string
+	alternatives []string
+}
+
+// NameTemplate derives a name from the entry attributes.
+// Placeholders are in curly braces, e.g. "{sn|displayName}", taking the first non-empty
+// alternative. A quoted alternative is a default value, e.g. {sn|"Unknown"}.
+// An "<attr>.first" alternative is all but the last word of the attribute, "<attr>.last" is the last word.
+type NameTemplate struct {
+	source string
+	parts  []*nameTemplatePart
+}
+
+// ParseNameTemplate parses the template text
+func ParseNameTemplate(source string) (*NameTemplate, error) {
+	tpl := &NameTemplate{source: source, parts: make([]*nameTemplatePart, 0)}
+	text := source
+	for text != "" {
+		start := strings.Index(text, "{")
+		if start < 0 {
+			tpl.parts = append(tpl.parts, &nameTemplatePart{literal: text})
+			break
+		}
+		if start > 0 {
+			tpl.parts = append(tpl.parts, &nameTemplatePart{literal: text[:start]})
+		}
+
+		end := strings.Index(text[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in the name template '%s'", source)
+		}
+
+		part := &nameTemplatePart{alternatives: make([]string, 0)}
+		for _, alt := range strings.Split(text[start+1:start+end], "|") {
+			if alt = strings.TrimSpace(alt); alt == "" {
+				return nil, fmt.Errorf("empty alternative in the name template '%s'", source)
+			}
+			part.alternatives = append(part.alternatives, alt)
+		}
+		tpl.parts = append(tpl.parts, part)
+		text = text[start+end+1:]
+	}
+
+	return tpl, nil
+}
+
+// String returns the template text
+func (tpl *NameTemplate) String() string {
+	return tpl.source
+}
+
+// Expand the template, getting attribute values by their names.
+// Whitespace of the result is collapsed.
+func (tpl *NameTemplate) Expand(get func(attr string) string) string {
+	var out strings.Builder
+	for _, part := range tpl.parts {
+		if part.alternatives == nil {
+			out.WriteString(part.literal)
+			continue
+		}
+
+		for _, alt := range part.alternatives {
+			value := ""
+			if len(alt) > 1 && alt[0] == '"' && alt[len(alt)-1] == '"' {
+				value = alt[1 : len(alt)-1]
+			} else {
+				value = tpl.value(alt, get)
+			}
+
+			if strings.TrimSpace(value) != "" {
+				out.WriteString(value)
+				break
+			}
+		}
+	}
+
+	return strings.Join(strings.Fields(out.String()), " ")
+}
+
+// Get the value of the attribute, or of its first or last words
+func (tpl *NameTemplate) value(attr string, get func(attr string) string) string {
+	for suffix, first := range map[string]bool{".first": true, ".last": false} {
+		if !strings.HasSuffix(attr, suffix) {
+			continue
+		}
+
+		words := strings.Fields(get(strings.TrimSuffix(attr, suffix)))
+		if len(words) < 2 {
+			return ""
+		}
+		if first {
+			return strings.Join(words[:len(words)-1], " ")
+		}
+		return words[len(words)-1]
+	}
+
+	return get(attr)
+}
@@ -0,0 +1,226 @@
+package ldapsync
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/thoas/go-funk"
+)
+
+// SourceOverride is the source of the grants by the local per-user overrides
+const SourceOverride = "override"
+
+// UserOverride pins roles or account fields of a single user, regardless of the directory.
+// It is applied only until its expiration, if any.
+type UserOverride struct {
+	Add        []string   `yaml:"add"`
+	Remove     []string   `yaml:"remove"`
+	Email      string     `yaml:"email"`
+	Name       string     `yaml:"name"`
+	Secondname string     `yaml:"secondname"`
+	Reason     string     `yaml:"reason"`
+	ValidUntil *time.Time `yaml:"valid_until"`
+}
+
+// IsExpired returns true if the override is no longer valid at the time
+func (uo *UserOverride) IsExpired(now time.Time) bool {
+	return uo.ValidUntil != nil && !now.Before(*uo.ValidUntil)
+}
+
+// AppliedOverride describes what the override changed on the user, compared to the directory.
+// RolesKept are the removals that did not take effect, as the roles are implied by the remaining "org_admin".
+type AppliedOverride struct {
+	Uid          string         `json:"uid" yaml:"uid"`
+	Reason       string         `json:"reason,omitempty" yaml:"reason,omitempty"`
+	ValidUntil   *time.Time     `json:"valid_until,omitempty" yaml:"valid_until,omitempty"`
+	Expired      bool           `json:"expired" yaml:"expired"`
+	RolesAdded   []string       `json:"roles_added,omitempty" yaml:"roles_added,omitempty"`
+	RolesRemoved []string       `json:"roles_removed,omitempty" yaml:"roles_removed,omitempty"`
+	RolesKept    []string       `json:"roles_kept,omitempty" yaml:"roles_kept,omitempty"`
+	Changes      []*FieldChange `json:"changes,omitempty" yaml:"changes,omitempty"`
+}
+
+// UserOverrides is a local file of the overrides, keyed by the login
+type UserOverrides struct {
+	path  string
+	Users map[string]*UserOverride
+}
+
+// NewUserOverrides is a constructor for the UserOverrides object
+func NewUserOverrides(path string) *UserOverrides {
+	overrides := new(UserOverrides)
+	overrides.path = path
+	overrides.Users = make(map[string]*UserOverride)
+
+	return overrides
+}
+
+// Load the overrides from the file. A missing file means no overrides.
+func (overrides *UserOverrides) Load() error {
+	overrides.Users = make(map[string]*UserOverride)
+
+	buff, err := ioutil.ReadFile(overrides.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := yaml.Unmarshal(buff, &overrides.Users); err != nil {
+		return err
+	}
+	if overrides.Users == nil {
+		overrides.Users = make(map[string]*UserOverride)
+	}
+
+	return overrides.validate()
+}
+
+// Check every override changes something and refers only to known roles
+func (overrides *UserOverrides) validate() error {
+	for uid, uo := range overrides.Users {
+		if uo != nil {
+			uo.Add = funk.Map(uo.Add, strings.ToLower).([]string)
+			uo.Remove = funk.Map(uo.Remove, strings.ToLower).([]string)
+		}
+		if uo == nil || len(uo.Add) == 0 && len(uo.Remove) == 0 && uo.Email == "" && uo.Name == "" && uo.Secondname == "" {
+			return fmt.Errorf("override of '%s' changes nothing", uid)
+		}
+		for _, role := range append(append([]string{}, uo.Add...), uo.Remove...) {
+			if !IsPossibleRole(role) {
+				return fmt.Errorf("override of '%s' has unknown Uyuni role '%s'", uid, role)
+			}
+		}
+		for _, role := range uo.Add {
+			if funk.ContainsString(uo.Remove, role) {
+				return fmt.Errorf("override of '%s' both adds and removes role '%s'", uid, role)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get the override of the login, if any
+func (overrides *UserOverrides) get(uid string, key func(string) string) (string, *UserOverride) {
+	for login, uo := range overrides.Users {
+		if key(login) == key(uid) {
+			return login, uo
+		}
+	}
+
+	return "", nil
+}
+
+// Apply the local override to the user, already resolved from the directory.
+// Roles are added and removed after the deny mappings, so the override always wins.
+func (sync *LDAPSync) applyOverride(user *UyuniUser) {
+	if user.override != nil {
+		return
+	}
+
+	login, uo := sync.overrides.get(user.Uid, sync.loginKey)
+	if uo == nil {
+		return
+	}
+
+	applied := &AppliedOverride{Uid: login, Reason: uo.Reason, ValidUntil: uo.ValidUntil}
+	if uo.IsExpired(time.Now()) {
+		Log.Warnf("Override of user '%s' has expired at %s, remove it from the overrides file", login, uo.ValidUntil.String())
+		applied.Expired = true
+		user.override = applied
+		return
+	}
+
+	for _, change := range []*FieldChange{
+		{Field: "email", Before: user.Email, After: uo.Email},
+		{Field: "name", Before: user.Name, After: uo.Name},
+		{Field: "secondname", Before: user.Secondname, After: uo.Secondname},
+	} {
+		if change.After != "" && change.After != change.Before {
+			applied.Changes = append(applied.Changes, change)
+		}
+	}
+	if uo.Email != "" {
+		user.Email = uo.Email
+	}
+	if uo.Name != "" {
+		user.Name = uo.Name
+	}
+	if uo.Secondname != "" {
+		user.Secondname = uo.Secondname
+	}
+
+	before := append([]string{}, user.GetRoles()...)
+	grants := make([]*RoleGrant, 0, len(user.grants))
+	for _, grant := range user.grants {
+		if !funk.ContainsString(uo.Remove, strings.ToLower(grant.Role)) {
+			grants = append(grants, grant)
+		}
+	}
+	for _, role := range sync.grantableRoles(uo.Add) {
+		grants = append(grants, &RoleGrant{Role: role, Source: SourceOverride, Mapping: login, Path: []string{login},
+			ValidUntil: uo.ValidUntil})
+	}
+	user.grants = grants
+
+	user.FlushRoles()
+	for _, grant := range user.grants {
+		user.AddRoles(grant.Role)
+	}
+
+	for _, role := range user.GetRoles() {
+		if !funk.ContainsString(before, role) {
+			applied.RolesAdded = append(applied.RolesAdded, role)
+		}
+	}
+	for _, role := range before {
+		if !funk.ContainsString(user.GetRoles(), role) {
+			applied.RolesRemoved = append(applied.RolesRemoved, role)
+		}
+	}
+	for _, role := range uo.Remove {
+		if funk.ContainsString(before, role) && funk.ContainsString(user.GetRoles(), role) {
+			applied.RolesKept = append(applied.RolesKept, role)
+			Log.Warnf("Role '%s' of user %s cannot be removed by the override: it is implied by org_admin", role, user.Uid)
+		}
+	}
+
+	user.override = applied
+	Log.Debugf("User %s is overridden locally", user.Uid)
+}
+
+// Overrides returns the overrides of the LDAP users, including the expired ones
+func (sync *LDAPSync) Overrides() []*AppliedOverride {
+	overrides := make([]*AppliedOverride, 0)
+	for _, user := range sync.ldapusers {
+		if applied := user.GetOverride(); applied != nil {
+			overrides = append(overrides, applied)
+		}
+	}
+
+	sort.SliceStable(overrides, func(i, j int) bool { return overrides[i].Uid < overrides[j].Uid })
+
+	return overrides
+}
+
+// Warn about overrides of the logins, which are not synchronised from the directory
+func (sync *LDAPSync) verifyOverrides() {
+	for login := range sync.overrides.Users {
+		found := false
+		for _, user := range sync.ldapusers {
+			if sync.sameLogin(login, user.Uid) {
+				found = true
+				break
+			}
+		}
+		if !found && sync.isSelected(login) && !sync.isFrozen(login) {
+			Log.Warnf("Override of user '%s' is not applied: no such user is synchronised from the directory", login)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ldapsync
+
+// Statuses of the users in the overview
+const (
+	StatusNew      = "new"
+	StatusOutdated = "outdated"
+	StatusRemoved  = "removed"
+)
+
+// UserOverview describes what is going to happen to the user on the next sync
+type UserOverview struct {
+	Status         string   `json:"status" yaml:"status"`
+	Uid            string   `json:"uid" yaml:"uid"`
+	Dn             string   `json:"dn" yaml:"dn"`
+	Name           string   `json:"name" yaml:"name"`
+	Secondname     string   `json:"secondname" yaml:"secondname"`
+	Email          string   `json:"email" yaml:"email"`
+	Roles          []string `json:"roles" yaml:"roles"`
+	AccountChanged bool     `json:"account_changed" yaml:"account_changed"`
+	RolesChanged   bool     `json:"roles_changed" yaml:"roles_changed"`
+
+	Changes      []*FieldChange   `json:"changes,omitempty" yaml:"changes,omitempty"`
+	RolesAdded   []string         `json:"roles_added,omitempty" yaml:"roles_added,omitempty"`
+	RolesRemoved []string         `json:"roles_removed,omitempty" yaml:"roles_removed,omitempty"`
+	Denials      []*RoleDenial    `json:"denials,omitempty" yaml:"denials,omitempty"`
+	Override     *AppliedOverride `json:"override,omitempty" yaml:"override,omitempty"`
+}
+
+// NewUserOverview creates an overview of the user with the given status
+func NewUserOverview(status string, user *UyuniUser) *UserOverview {
+	uo := new(UserOverview)
+	uo.Status = status
+	uo.Uid = user.Uid
+	uo.Dn = user.Dn
+	uo.Name = user.Name
+	uo.Secondname = user.Secondname
+	uo.Email = user.Email
+	uo.Roles = append([]string{}, user.GetRoles()...)
+	uo.AccountChanged = user.IsAccountDataChanged()
+	uo.RolesChanged = user.IsRolesChanged()
+	uo.Changes = append([]*FieldChange{}, user.GetChanges()...)
+	uo.RolesAdded = append([]string{}, user.GetAddedRoles()...)
+	uo.RolesRemoved = append([]string{}, user.GetRemovedRoles()...)
+	uo.Denials = append([]*RoleDenial{}, user.GetDenials()...)
+	uo.Override = user.GetOverride()
+
+	return uo
+}
+
+// Overview of the changes the next sync is going to apply
+type Overview struct {
+	Frozen      []string           `json:"frozen" yaml:"frozen"`
+	Users       []*UserOverview    `json:"users" yaml:"users"`
+	Collisions  []*LoginCollision  `json:"collisions,omitempty" yaml:"collisions,omitempty"`
+	Renames     []*LoginRename     `json:"renames,omitempty" yaml:"renames,omitempty"`
+	Unmanaged   []*UserOverview    `json:"unmanaged,omitempty" yaml:"unmanaged,omitempty"`
+	Expirations []*RoleExpiration  `json:"expirations,omitempty" yaml:"expirations,omitempty"`
+	Overrides   []*AppliedOverride `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+}
+
+// Overview returns all the changes the next sync is going to apply, without applying them
+func (sync *LDAPSync) Overview() *Overview {
+	overview := new(Overview)
+	overview.Frozen = sync.FrozenUsers()
+	overview.Users = make([]*UserOverview, 0)
+	overview.Collisions = append([]*LoginCollision{}, sync.Collisions()...)
+	overview.Renames = append([]*LoginRename{}, sync.Renames()...)
+	overview.Expirations = sync.Expirations()
+	overview.Overrides = sync.Overrides()
+
+	for _, user := range sync.GetNewUsers() {
+		overview.Users = append(overview.Users, NewUserOverview(StatusNew, user))
+	}
+	for _, user := range sync.GetOutdatedUsers() {
+		overview.Users = append(overview.Users, NewUserOverview(StatusOutdated, user))
+	}
+	for _, user := range sync.GetDeletedUsers() {
+		overview.Users = append(overview.Users, NewUserOverview(StatusRemoved, user))
+	}
+
+	overview.Unmanaged = make([]*UserOverview, 0)
+	for _, user := range sync.getUnmanagedOutdatedUsers() {
+		overview.Unmanaged = append(overview.Unmanaged, NewUserOverview(StatusOutdated, user))
+	}
+	for _, user := range sync.getUnmanagedDeletedUsers() {
+		overview.Unmanaged = append(overview.Unmanaged, NewUserOverview(StatusRemoved, user))
+	}
+
+	return overview
+}
+
+// UsersByStatus returns users overview with the given status
+func (o *Overview) UsersByStatus(status string) []*UserOverview {
+	users := make([]*UserOverview, 0)
+	for _, user := range o.Users {
+		if user.Status == status {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
@@ -0,0 +1,121 @@
+package ldapsync
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Returns true if the Uyuni user has been created or adopted by the sync
+func (sync *LDAPSync) isManaged(uid string) bool {
+	return sync.state.IsManaged(sync.loginKey(uid))
+}
+
+// Get users that are outdated, but not managed by the sync
+func (sync *LDAPSync) getUnmanagedOutdatedUsers() []*UyuniUser {
+	var users []*UyuniUser
+	for _, user := range sync.uyuniusers {
+		if !user.IsNew() && user.IsOutdated() && !sync.isManaged(user.Uid) {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+// Get users that are removed from the admin-related groups, but not managed by the sync
+func (sync *LDAPSync) getUnmanagedDeletedUsers() []*UyuniUser {
+	var users []*UyuniUser
+	for _, user := range sync.allldapusers {
+		if sync.isSelected(user.Uid) && !sync.in(*user, sync.ldapusers) && sync.in(*user, sync.uyuniusers) && !sync.isManaged(user.Uid) {
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+// Adopt existing Uyuni users, so the sync manages them as if it created them.
+// With "all", every Uyuni user with a directory entry is adopted, except the frozen ones. Returns adopted logins.
+func (sync *LDAPSync) Adopt(uids []string, all bool) ([]string, error) {
+	candidates := make([]string, 0)
+	for _, user := range sync.uyuniusers {
+		if user.IsNew() || sync.isFrozen(user.Uid) {
+			continue
+		}
+		if all && (sync.in(*user, sync.ldapusers) || sync.in(*user, sync.allldapusers)) {
+			candidates = append(candidates, user.Uid)
+		}
+	}
+
+	for _, uid := range uids {
+		if sync.isFrozen(uid) {
+			return nil, fmt.Errorf("User '%s' is frozen", uid)
+		}
+		found := false
+		for _, user := range sync.uyuniusers {
+			if !user.IsNew() && sync.sameLogin(user.Uid, uid) {
+				candidates = append(candidates, user.Uid)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("User '%s' was not found in Uyuni", uid)
+		}
+	}
+
+	adopted := make([]string, 0)
+	for _, uid := range candidates {
+		if !sync.isManaged(uid) {
+			sync.state.Manage(sync.loginKey(uid))
+			adopted = append(adopted, uid)
+		}
+	}
+	sort.Strings(adopted)
+
+	if len(adopted) > 0 {
+		if err := sync.state.Save(); err != nil {
+			return nil, err
+		}
+		Log.Infof("Adopted %d users", len(adopted))
+	}
+
+	return adopted, nil
+}
+
+// Record the Uyuni logins of the immutable IDs and the managed logins after the sync.
+// Failed operations are not recorded, but a created user stays managed even if setting its roles failed.
+func (sync *LDAPSync) updateState(report *SyncReport) {
+	failed := make(map[string]bool)
+	for _, op := range report.Failed() {
+		failed[sync.loginKey(op.Uid)] = true
+	}
+
+	for _, user := range sync.ldapusers {
+		if user.Id != "" && !failed[sync.loginKey(user.Uid)] {
+			sync.state.SetLogin(user.Id, user.Uid)
+		}
+	}
+
+	for _, op := range report.Operations {
+		if op.Failed() {
+			continue
+		}
+		if op.Action == ActionCreate {
+			sync.state.Manage(sync.loginKey(op.Uid))
+			continue
+		}
+		if op.Action != ActionDelete {
+			continue
+		}
+		sync.state.Release(sync.loginKey(op.Uid))
+		for id, login := range sync.state.Logins {
+			if sync.sameLogin(login, op.Uid) {
+				sync.state.Forget(id)
+			}
+		}
+	}
+
+	if err := sync.state.Save(); err != nil {
+		Log.Errorf("Unable to save the sync state: %s", err.Error())
+	}
+}
@@ -0,0 +1,79 @@
+package ldapsync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap"
+)
+
+// DefaultIdattributes are looked up for the immutable ID of the entry, in this order
+var DefaultIdattributes = []string{"entryUUID", "objectGUID", "nsUniqueId", "ipaUniqueID", "orclGUID"}
+
+// LoginRename describes a user, whose login has been changed in the directory.
+// Uyuni cannot rename logins, so the account keeps its login and is updated as usual.
+type LoginRename struct {
+	Id           string `json:"id" yaml:"id"`
+	Dn           string `json:"dn" yaml:"dn"`
+	Login        string `json:"login" yaml:"login"`
+	DirectoryUid string `json:"directory_uid" yaml:"directory_uid"`
+}
+
+// Get the immutable ID of the entry from the first present ID attribute
+func (sync *LDAPSync) immutableID(entry *ldap.Entry) string {
+	for _, attr := range sync.cr.Config().Directory.Idattributes {
+		for _, a := range entry.Attributes {
+			if !strings.EqualFold(a.Name, attr) || len(a.Values) == 0 {
+				continue
+			}
+
+			raw := []byte(a.Values[0])
+			if len(a.ByteValues) > 0 {
+				raw = a.ByteValues[0]
+			}
+
+			// Active Directory GUID is binary, with the first three fields in little-endian
+			if strings.EqualFold(attr, "objectGUID") && len(raw) == 16 {
+				return strings.ToLower(fmt.Sprintf("%x-%x-%x-%x-%x",
+					[]byte{raw[3], raw[2], raw[1], raw[0]}, []byte{raw[5], raw[4]}, []byte{raw[7], raw[6]}, raw[8:10], raw[10:]))
+			}
+
+			return strings.ToLower(strings.TrimSpace(a.Values[0]))
+		}
+	}
+
+	return ""
+}
+
+// Detect users, whose login has been changed in the directory since the last sync.
+// If Uyuni still has the previous login, the user keeps it instead of being deleted and created again.
+func (sync *LDAPSync) resolveRenames() {
+	sync.renames = make([]*LoginRename, 0)
+	renamed := make(map[string]string)
+
+	for _, users := range [][]*UyuniUser{sync.ldapusers, sync.allldapusers} {
+		for _, user := range users {
+			if user.Id == "" {
+				continue
+			}
+
+			login := sync.state.Login(user.Id)
+			if login == "" || sync.sameLogin(login, user.Uid) || !sync.in(UyuniUser{Uid: login}, sync.uyuniusers) {
+				continue
+			}
+
+			if _, ext := renamed[user.Id]; !ext {
+				renamed[user.Id] = user.Uid
+				sync.renames = append(sync.renames, &LoginRename{Id: user.Id, Dn: user.Dn, Login: login, DirectoryUid: user.Uid})
+				Log.Warnf("User '%s' has been renamed to '%s' in the directory. Uyuni cannot rename logins, keeping '%s'",
+					login, user.Uid, login)
+			}
+			user.Uid = login
+		}
+	}
+}
+
+// Renames returns users, whose login has been changed in the directory
+func (sync *LDAPSync) Renames() []*LoginRename {
+	return sync.renames
+}
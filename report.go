@@ -0,0 +1,108 @@
+package ldapsync
+
+import (
+	"time"
+)
+
+// Operations, performed on the Uyuni users
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Exit codes of the sync run
+const (
+	ExitClean          = 0
+	ExitAborted        = 1
+	ExitChangesApplied = 2
+	ExitPartialFailure = 3
+)
+
+// Outcomes of the sync run
+const (
+	StatusClean          = "clean"
+	StatusChangesApplied = "changes applied"
+	StatusPartialFailure = "partial failure"
+)
+
+// SyncOperation is a single attempted operation on the Uyuni user and its result
+type SyncOperation struct {
+	Uid    string `json:"uid" yaml:"uid"`
+	Action string `json:"action" yaml:"action"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Failed returns true if the operation was not successful
+func (op *SyncOperation) Failed() bool {
+	return op.Error != ""
+}
+
+// SyncReport captures every attempted operation of the sync run
+type SyncReport struct {
+	Started    time.Time        `json:"started" yaml:"started"`
+	Finished   time.Time        `json:"finished" yaml:"finished"`
+	Status     string           `json:"status" yaml:"status"`
+	Operations []*SyncOperation `json:"operations" yaml:"operations"`
+
+	Overrides []*AppliedOverride `json:"overrides,omitempty" yaml:"overrides,omitempty"`
+}
+
+// NewSyncReport creates an instance of SyncReport
+func NewSyncReport() *SyncReport {
+	report := new(SyncReport)
+	report.Started = time.Now()
+	report.Status = StatusClean
+	report.Operations = make([]*SyncOperation, 0)
+
+	return report
+}
+
+// Add an attempted operation to the report
+func (r *SyncReport) Add(uid string, action string, err error) *SyncOperation {
+	op := &SyncOperation{Uid: uid, Action: action}
+	if err != nil {
+		op.Error = err.Error()
+	}
+	r.Operations = append(r.Operations, op)
+
+	return op
+}
+
+// Finish the report and set the outcome
+func (r *SyncReport) Finish() *SyncReport {
+	r.Finished = time.Now()
+	r.Status = StatusClean
+	if len(r.Operations) > 0 {
+		r.Status = StatusChangesApplied
+	}
+	if len(r.Failed()) > 0 {
+		r.Status = StatusPartialFailure
+	}
+
+	return r
+}
+
+// Failed returns all operations that were not successful
+func (r *SyncReport) Failed() []*SyncOperation {
+	ops := make([]*SyncOperation, 0)
+	for _, op := range r.Operations {
+		if op.Failed() {
+			ops = append(ops, op)
+		}
+	}
+
+	return ops
+}
+
+// ExitCode returns the process exit code, corresponding to the outcome
+func (r *SyncReport) ExitCode() int {
+	switch r.Status {
+	case StatusChangesApplied:
+		return ExitChangesApplied
+	case StatusPartialFailure:
+		return ExitPartialFailure
+	default:
+		return ExitClean
+	}
+}
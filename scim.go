@@ -0,0 +1,853 @@
+package ldapsync
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap"
+)
+
+// SCIM schemas
+const (
+	SCIMSchemaUser      = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup     = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaList      = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatch     = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError     = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMSchemaSPConfig  = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimPrefix          = "/scim/v2"
+	scimContentType     = "application/scim+json"
+	scimSyncDebounce    = 2 * time.Second
+	scimRetryInterval   = time.Minute
+	scimDefaultPageSize = 100
+)
+
+// SCIMName is a name of the SCIM user
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMValue is an element of the SCIM multi-valued attribute, such as emails or members
+type SCIMValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// SCIMMeta is the metadata of the SCIM resource
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// SCIMUser is the SCIM Users resource
+type SCIMUser struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id"`
+	ExternalId  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *SCIMName    `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []*SCIMValue `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// IsActive returns true, unless the user was explicitly deactivated
+func (u *SCIMUser) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// Email returns the primary email address or the first one
+func (u *SCIMUser) Email() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+
+	return ""
+}
+
+// SCIMGroup is the SCIM Groups resource
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id"`
+	ExternalId  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []*SCIMValue `json:"members,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// SCIMPatchOperation is a single operation of the PATCH request
+type SCIMPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// SCIMPatch is the PATCH request
+type SCIMPatch struct {
+	Schemas    []string              `json:"schemas"`
+	Operations []*SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMError is an error response
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// Error returns the error details
+func (e *SCIMError) Error() string {
+	return e.Detail
+}
+
+// Create a SCIM error
+func newSCIMError(status int, scimType string, format string, args ...interface{}) *SCIMError {
+	return &SCIMError{Schemas: []string{SCIMSchemaError}, Status: strconv.Itoa(status),
+		ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// SCIMServer receives users and groups, pushed by the identity provider via SCIM 2.0.
+// Received resources are published as directory entries (users under "allusers" DN, groups
+// under "groupsbase" DN), so the same role mappings and Uyuni writes are applied as with LDAP.
+// Resources are kept in the memory only, the identity provider is expected to push them again.
+type SCIMServer struct {
+	ls      *LDAPSync
+	dir     *MemoryDirectory
+	token   string
+	users   map[string]*SCIMUser
+	groups  map[string]*SCIMGroup
+	deleted map[string]*SCIMUser
+	lock    sync.Mutex
+	trigger chan bool
+}
+
+// NewSCIMServer creates an instance of SCIMServer, feeding the given LDAPSync
+func NewSCIMServer(ls *LDAPSync) *SCIMServer {
+	srv := new(SCIMServer)
+	srv.ls = ls
+	srv.token = ls.ConfigReader().Config().Scim.Token
+	srv.users = make(map[string]*SCIMUser)
+	srv.groups = make(map[string]*SCIMGroup)
+	srv.deleted = make(map[string]*SCIMUser)
+	srv.trigger = make(chan bool, 1)
+
+	dir, ok := ls.Directory().(*MemoryDirectory)
+	if !ok {
+		Log.Fatal("SCIM server requires the directory source to be 'scim'")
+	}
+	srv.dir = dir
+
+	return srv
+}
+
+// ListenAndServe starts the SCIM endpoint and the background sync, as configured
+func (srv *SCIMServer) ListenAndServe() error {
+	go srv.syncLoop()
+
+	cfg := srv.ls.ConfigReader().Config().Scim
+	Log.Infof("SCIM endpoint is listening on %s%s", cfg.Listen, scimPrefix)
+	if cfg.Certfile != "" && cfg.Keyfile != "" {
+		return http.ListenAndServeTLS(cfg.Listen, cfg.Certfile, cfg.Keyfile, srv.Handler())
+	}
+
+	return http.ListenAndServe(cfg.Listen, srv.Handler())
+}
+
+// Handler returns HTTP handler of the SCIM endpoint
+func (srv *SCIMServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(scimPrefix+"/ServiceProviderConfig", srv.handleServiceProviderConfig)
+	mux.HandleFunc(scimPrefix+"/Users", srv.handleUsers)
+	mux.HandleFunc(scimPrefix+"/Users/", srv.handleUsers)
+	mux.HandleFunc(scimPrefix+"/Groups", srv.handleGroups)
+	mux.HandleFunc(scimPrefix+"/Groups/", srv.handleGroups)
+
+	return srv.authenticate(mux)
+}
+
+// Check the bearer token
+func (srv *SCIMServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(srv.token)) != 1 {
+			srv.reply(w, http.StatusUnauthorized, newSCIMError(http.StatusUnauthorized, "", "Invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Write a JSON reply
+func (srv *SCIMServer) reply(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	if status == http.StatusNoContent {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		Log.Errorf("Unable to write SCIM response: %s", err.Error())
+	}
+}
+
+// Write an error reply
+func (srv *SCIMServer) fail(w http.ResponseWriter, err error) {
+	scimErr, ok := err.(*SCIMError)
+	if !ok {
+		scimErr = newSCIMError(http.StatusBadRequest, "invalidValue", "%s", err.Error())
+	}
+	status, _ := strconv.Atoi(scimErr.Status)
+	srv.reply(w, status, scimErr)
+}
+
+// Generate a new resource ID
+func (srv *SCIMServer) newId() string {
+	buff := make([]byte, 16)
+	if _, err := rand.Read(buff); err != nil {
+		Log.Fatal(err)
+	}
+	buff[6] = (buff[6] & 0x0f) | 0x40
+	buff[8] = (buff[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buff[0:4], buff[4:6], buff[6:8], buff[8:10], buff[10:])
+}
+
+// Get the resource ID from the URL path, if any
+func (srv *SCIMServer) resourceId(r *http.Request, resource string) string {
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, scimPrefix+"/"+resource), "/")
+}
+
+// Parse a simple "attribute eq value" filter of the list request
+func (srv *SCIMServer) parseFilter(filter string) (string, string, error) {
+	if filter == "" {
+		return "", "", nil
+	}
+
+	m := regexp.MustCompile(`(?i)^\s*([\w.]+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*$`).FindStringSubmatch(filter)
+	if m == nil {
+		return "", "", newSCIMError(http.StatusBadRequest, "invalidFilter", "Unsupported filter: %s", filter)
+	}
+	value, err := strconv.Unquote(`"` + m[2] + `"`)
+	if err != nil {
+		return "", "", newSCIMError(http.StatusBadRequest, "invalidFilter", "Invalid filter value: %s", filter)
+	}
+
+	return strings.ToLower(m[1]), value, nil
+}
+
+// Reply with a page of the resources, as requested
+func (srv *SCIMServer) replyList(w http.ResponseWriter, r *http.Request, resources []interface{}) {
+	start, err := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 {
+		count = scimDefaultPageSize
+	}
+
+	page := make([]interface{}, 0)
+	for idx := start - 1; idx < len(resources) && len(page) < count; idx++ {
+		page = append(page, resources[idx])
+	}
+
+	srv.reply(w, http.StatusOK, map[string]interface{}{
+		"schemas":      []string{SCIMSchemaList},
+		"totalResults": len(resources),
+		"startIndex":   start,
+		"itemsPerPage": len(page),
+		"Resources":    page,
+	})
+}
+
+// Report SCIM capabilities
+func (srv *SCIMServer) handleServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	srv.reply(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{SCIMSchemaSPConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimDefaultPageSize},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{"type": "oauthbearertoken", "name": "OAuth Bearer Token",
+			"description": "Authentication with the bearer token"}},
+	})
+}
+
+// Decode the request body
+func (srv *SCIMServer) decode(r *http.Request, data interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		return newSCIMError(http.StatusBadRequest, "invalidSyntax", "Invalid request: %s", err.Error())
+	}
+	return nil
+}
+
+// Apply PATCH operations to the resource, storing the result to the target
+func (srv *SCIMServer) patch(r *http.Request, resource interface{}, target interface{}) error {
+	req := new(SCIMPatch)
+	if err := srv.decode(r, req); err != nil {
+		return err
+	}
+
+	buff, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+	data := make(map[string]interface{})
+	if err = json.Unmarshal(buff, &data); err != nil {
+		return err
+	}
+
+	for _, op := range req.Operations {
+		if err = scimPatch(data, op); err != nil {
+			return err
+		}
+	}
+
+	// Some identity providers send booleans as strings
+	if active, ok := data["active"].(string); ok {
+		data["active"], _ = strconv.ParseBool(active)
+	}
+
+	if buff, err = json.Marshal(data); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(buff, target); err != nil {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "Invalid resource after patch: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Users resource
+func (srv *SCIMServer) handleUsers(w http.ResponseWriter, r *http.Request) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	id := srv.resourceId(r, "Users")
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			attr, value, err := srv.parseFilter(r.URL.Query().Get("filter"))
+			if err != nil {
+				srv.fail(w, err)
+				return
+			}
+			resources := make([]interface{}, 0)
+			for _, user := range srv.sortedUsers() {
+				if attr == "" || (attr == "username" && strings.EqualFold(user.UserName, value)) ||
+					(attr == "externalid" && user.ExternalId == value) || (attr == "id" && user.Id == value) {
+					resources = append(resources, user)
+				}
+			}
+			srv.replyList(w, r, resources)
+		case http.MethodPost:
+			user := new(SCIMUser)
+			if err := srv.decode(r, user); err != nil {
+				srv.fail(w, err)
+				return
+			}
+			user.Id = srv.newId()
+			if err := srv.storeUser(user, true); err != nil {
+				srv.fail(w, err)
+				return
+			}
+			srv.reply(w, http.StatusCreated, user)
+		default:
+			srv.fail(w, newSCIMError(http.StatusMethodNotAllowed, "", "Method %s is not allowed", r.Method))
+		}
+		return
+	}
+
+	user, ext := srv.users[id]
+	if !ext {
+		srv.fail(w, newSCIMError(http.StatusNotFound, "", "User %s not found", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.reply(w, http.StatusOK, user)
+	case http.MethodPut, http.MethodPatch:
+		updated := new(SCIMUser)
+		var err error
+		if r.Method == http.MethodPut {
+			err = srv.decode(r, updated)
+		} else {
+			err = srv.patch(r, user, updated)
+		}
+		if err == nil {
+			updated.Id, updated.Meta = user.Id, user.Meta
+			err = srv.storeUser(updated, false)
+		}
+		if err != nil {
+			srv.fail(w, err)
+			return
+		}
+		srv.reply(w, http.StatusOK, updated)
+	case http.MethodDelete:
+		delete(srv.users, id)
+		srv.deleted[id] = user
+		for _, group := range srv.groups {
+			group.Members = srv.withoutMember(group.Members, id)
+		}
+		srv.changed()
+		srv.reply(w, http.StatusNoContent, nil)
+	default:
+		srv.fail(w, newSCIMError(http.StatusMethodNotAllowed, "", "Method %s is not allowed", r.Method))
+	}
+}
+
+// Validate and store the user
+func (srv *SCIMServer) storeUser(user *SCIMUser, created bool) error {
+	if user.UserName == "" {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "Attribute userName is required")
+	}
+	for _, other := range srv.users {
+		if other.Id != user.Id && strings.EqualFold(other.UserName, user.UserName) {
+			return newSCIMError(http.StatusConflict, "uniqueness", "User %s already exists", user.UserName)
+		}
+	}
+
+	now := time.Now().UTC()
+	if created || user.Meta == nil {
+		user.Meta = &SCIMMeta{ResourceType: "User", Created: now}
+	}
+	user.Meta.LastModified = now
+	user.Meta.Location = scimPrefix + "/Users/" + user.Id
+	user.Schemas = []string{SCIMSchemaUser}
+	srv.users[user.Id] = user
+	srv.changed()
+
+	return nil
+}
+
+// Groups resource
+func (srv *SCIMServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	id := srv.resourceId(r, "Groups")
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			attr, value, err := srv.parseFilter(r.URL.Query().Get("filter"))
+			if err != nil {
+				srv.fail(w, err)
+				return
+			}
+			resources := make([]interface{}, 0)
+			for _, group := range srv.sortedGroups() {
+				if attr == "" || (attr == "displayname" && strings.EqualFold(group.DisplayName, value)) ||
+					(attr == "externalid" && group.ExternalId == value) || (attr == "id" && group.Id == value) {
+					resources = append(resources, group)
+				}
+			}
+			srv.replyList(w, r, resources)
+		case http.MethodPost:
+			group := new(SCIMGroup)
+			if err := srv.decode(r, group); err != nil {
+				srv.fail(w, err)
+				return
+			}
+			group.Id = srv.newId()
+			if err := srv.storeGroup(group, true); err != nil {
+				srv.fail(w, err)
+				return
+			}
+			srv.reply(w, http.StatusCreated, group)
+		default:
+			srv.fail(w, newSCIMError(http.StatusMethodNotAllowed, "", "Method %s is not allowed", r.Method))
+		}
+		return
+	}
+
+	group, ext := srv.groups[id]
+	if !ext {
+		srv.fail(w, newSCIMError(http.StatusNotFound, "", "Group %s not found", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.reply(w, http.StatusOK, group)
+	case http.MethodPut, http.MethodPatch:
+		updated := new(SCIMGroup)
+		var err error
+		if r.Method == http.MethodPut {
+			err = srv.decode(r, updated)
+		} else {
+			err = srv.patch(r, group, updated)
+		}
+		if err == nil {
+			updated.Id, updated.Meta = group.Id, group.Meta
+			err = srv.storeGroup(updated, false)
+		}
+		if err != nil {
+			srv.fail(w, err)
+			return
+		}
+		srv.reply(w, http.StatusOK, updated)
+	case http.MethodDelete:
+		delete(srv.groups, id)
+		srv.changed()
+		srv.reply(w, http.StatusNoContent, nil)
+	default:
+		srv.fail(w, newSCIMError(http.StatusMethodNotAllowed, "", "Method %s is not allowed", r.Method))
+	}
+}
+
+// Validate and store the group
+func (srv *SCIMServer) storeGroup(group *SCIMGroup, created bool) error {
+	if group.DisplayName == "" {
+		return newSCIMError(http.StatusBadRequest, "invalidValue", "Attribute displayName is required")
+	}
+	for _, other := range srv.groups {
+		if other.Id != group.Id && strings.EqualFold(other.DisplayName, group.DisplayName) {
+			return newSCIMError(http.StatusConflict, "uniqueness", "Group %s already exists", group.DisplayName)
+		}
+	}
+	for _, member := range group.Members {
+		if _, ext := srv.users[member.Value]; !ext {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "Member %s is not a known user", member.Value)
+		}
+	}
+
+	now := time.Now().UTC()
+	if created || group.Meta == nil {
+		group.Meta = &SCIMMeta{ResourceType: "Group", Created: now}
+	}
+	group.Meta.LastModified = now
+	group.Meta.Location = scimPrefix + "/Groups/" + group.Id
+	group.Schemas = []string{SCIMSchemaGroup}
+	srv.groups[group.Id] = group
+	srv.changed()
+
+	return nil
+}
+
+// Remove the member from the list
+func (srv *SCIMServer) withoutMember(members []*SCIMValue, id string) []*SCIMValue {
+	remaining := make([]*SCIMValue, 0, len(members))
+	for _, member := range members {
+		if member.Value != id {
+			remaining = append(remaining, member)
+		}
+	}
+
+	return remaining
+}
+
+// Users, sorted by the user name
+func (srv *SCIMServer) sortedUsers() []*SCIMUser {
+	users := make([]*SCIMUser, 0, len(srv.users))
+	for _, user := range srv.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].UserName < users[j].UserName })
+
+	return users
+}
+
+// Groups, sorted by the display name
+func (srv *SCIMServer) sortedGroups() []*SCIMGroup {
+	groups := make([]*SCIMGroup, 0, len(srv.groups))
+	for _, group := range srv.groups {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].DisplayName < groups[j].DisplayName })
+
+	return groups
+}
+
+// Request a sync after the resources were changed
+func (srv *SCIMServer) changed() {
+	select {
+	case srv.trigger <- true:
+	default:
+	}
+}
+
+// Run the sync on changes, waiting for the changes to settle first.
+// A failed sync is retried later, the server keeps running.
+func (srv *SCIMServer) syncLoop() {
+	for range srv.trigger {
+		time.Sleep(scimSyncDebounce)
+		select {
+		case <-srv.trigger:
+		default:
+		}
+
+		published := srv.publish()
+		if err := srv.ls.Refresh(); err != nil {
+			Log.Errorf("SCIM sync failed, retrying in %s: %s", scimRetryInterval, err.Error())
+			time.AfterFunc(scimRetryInterval, srv.changed)
+			continue
+		}
+		report := srv.ls.SyncUsers()
+		if srv.settle(published, report) > 0 {
+			time.AfterFunc(scimRetryInterval, srv.changed)
+		}
+		Log.Infof("SCIM sync finished: %s", report.Status)
+	}
+}
+
+// Forget the published deleted users, unless their removal from Uyuni failed.
+// Returns the amount of deleted users, still pending.
+func (srv *SCIMServer) settle(published []*SCIMUser, report *SyncReport) int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	failed := make(map[string]bool)
+	for _, op := range report.Failed() {
+		if op.Action == ActionDelete {
+			failed[srv.ls.loginKey(op.Uid)] = true
+		}
+	}
+
+	for _, user := range published {
+		if failed[srv.ls.loginKey(user.UserName)] {
+			Log.Warnf("Removal of the deleted SCIM user '%s' failed, it is retried", user.UserName)
+			continue
+		}
+		if srv.deleted[user.Id] == user {
+			delete(srv.deleted, user.Id)
+		}
+	}
+
+	return len(srv.deleted)
+}
+
+// Publish the resources as directory entries. Returns the published deleted users.
+// Deactivated and deleted users are kept in the directory, but not in the groups, so they are removed from Uyuni.
+// Deleted users are published until their removal succeeds.
+func (srv *SCIMServer) publish() []*SCIMUser {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	cfg := srv.ls.ConfigReader().Config().Directory
+	entries := make([]*ldap.Entry, 0)
+	dns := make(map[string]string)
+
+	users := make([]*SCIMUser, 0, len(srv.deleted)+len(srv.users))
+	for _, user := range srv.deleted {
+		users = append(users, user)
+	}
+	published := append([]*SCIMUser{}, users...)
+
+	// Existing users come last to replace deleted ones with the same name
+	for _, user := range append(users, srv.sortedUsers()...) {
+		dn := fmt.Sprintf("uid=%s,%s", EscapeDNValue(user.UserName), cfg.Allusers)
+		name := &SCIMName{}
+		if user.Name != nil {
+			name = user.Name
+		}
+		entry := NewPersonEntry(dn, user.UserName, name.GivenName, name.FamilyName, user.Email())
+		entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute("entryUUID", []string{user.Id}))
+		if user.DisplayName != "" {
+			entry.Attributes = append(entry.Attributes, ldap.NewEntryAttribute("displayName", []string{user.DisplayName}))
+		}
+		entries = append(entries, entry)
+
+		if _, ext := srv.users[user.Id]; ext && user.IsActive() {
+			dns[user.Id] = dn
+		}
+	}
+
+	for _, group := range srv.sortedGroups() {
+		members := make([]string, 0)
+		for _, member := range group.Members {
+			if dn, ext := dns[member.Value]; ext {
+				members = append(members, dn)
+			}
+		}
+		entries = append(entries, NewGroupEntry(fmt.Sprintf("cn=%s,%s", EscapeDNValue(group.DisplayName), cfg.Groupsbase),
+			group.DisplayName, members))
+	}
+
+	srv.dir.Reset(entries)
+
+	return published
+}
+
+// Apply a single PATCH operation to the resource data
+func scimPatch(data map[string]interface{}, op *SCIMPatchOperation) error {
+	opName := strings.ToLower(op.Op)
+	if opName != "add" && opName != "remove" && opName != "replace" {
+		return newSCIMError(http.StatusBadRequest, "invalidSyntax", "Unknown operation: %s", op.Op)
+	}
+
+	if op.Path == "" {
+		values, ok := op.Value.(map[string]interface{})
+		if !ok || opName == "remove" {
+			return newSCIMError(http.StatusBadRequest, "noTarget", "Operation %s requires a path", op.Op)
+		}
+		for path, value := range values {
+			if err := scimPatch(data, &SCIMPatchOperation{Op: op.Op, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path := op.Path
+	for _, schema := range []string{SCIMSchemaUser, SCIMSchemaGroup} {
+		if strings.HasPrefix(strings.ToLower(path), strings.ToLower(schema)+":") {
+			path = path[len(schema)+1:]
+		}
+	}
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		// Extension schemas are not relevant to Uyuni
+		return nil
+	}
+
+	m := regexp.MustCompile(`^(\w+)(?:\[\s*(\w+)\s+eq\s+"((?:[^"\\]|\\.)*)"\s*\])?(?:\.(\w+))?$`).FindStringSubmatch(path)
+	if m == nil {
+		return newSCIMError(http.StatusBadRequest, "invalidPath", "Unsupported path: %s", op.Path)
+	}
+	attr, filterAttr, filterValue, subAttr := scimKey(data, m[1]), m[2], m[3], m[4]
+
+	// Simple or complex attribute, e.g. "active" or "name.givenName"
+	if filterAttr == "" {
+		target := data
+		if subAttr != "" {
+			if _, ok := data[attr].([]interface{}); ok {
+				return newSCIMError(http.StatusBadRequest, "invalidPath", "Multi-valued path requires a filter: %s", op.Path)
+			}
+			sub, ok := data[attr].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				data[attr] = sub
+			}
+			target, attr = sub, scimKey(sub, subAttr)
+		}
+
+		existing, multi := target[attr].([]interface{})
+		values, isList := op.Value.([]interface{})
+		switch {
+		case opName == "remove" && multi && isList:
+			// Remove only the given values, e.g. particular members
+			for _, value := range values {
+				existing = scimRemoveValues(existing, "value", scimValue(value))
+			}
+			target[attr] = existing
+		case opName == "remove":
+			delete(target, attr)
+		case opName == "add" && multi && isList:
+			for _, value := range values {
+				if len(scimFilterValues(existing, "value", scimValue(value))) == 0 {
+					existing = append(existing, value)
+				}
+			}
+			target[attr] = existing
+		default:
+			target[attr] = op.Value
+		}
+
+		return nil
+	}
+
+	// Filtered multi-valued attribute, e.g. members[value eq "id"] or emails[type eq "work"].value
+	existing, _ := data[attr].([]interface{})
+	matching := scimFilterValues(existing, filterAttr, filterValue)
+	if len(matching) == 0 && opName != "add" {
+		if opName == "remove" {
+			return nil
+		}
+		return newSCIMError(http.StatusBadRequest, "noTarget", "No values match the path: %s", op.Path)
+	}
+
+	switch {
+	case opName == "remove" && subAttr == "":
+		data[attr] = scimRemoveValues(existing, filterAttr, filterValue)
+	case subAttr != "":
+		for _, elem := range matching {
+			if opName == "remove" {
+				delete(elem, scimKey(elem, subAttr))
+			} else {
+				elem[scimKey(elem, subAttr)] = op.Value
+			}
+		}
+	default:
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return newSCIMError(http.StatusBadRequest, "invalidValue", "Complex value is expected for the path: %s", op.Path)
+		}
+		for _, elem := range matching {
+			for key, value := range values {
+				elem[scimKey(elem, key)] = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// Find the existing key of the attribute, as the attribute names are case-insensitive
+func scimKey(data map[string]interface{}, attr string) string {
+	for key := range data {
+		if strings.EqualFold(key, attr) {
+			return key
+		}
+	}
+
+	return attr
+}
+
+// Get the "value" of the multi-valued attribute element, given either as an object or as a plain value
+func scimValue(value interface{}) string {
+	if elem, ok := value.(map[string]interface{}); ok {
+		value = elem[scimKey(elem, "value")]
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// Get elements of the multi-valued attribute, having the attribute equal to the value
+func scimFilterValues(values []interface{}, attr string, value string) []map[string]interface{} {
+	matching := make([]map[string]interface{}, 0)
+	for _, v := range values {
+		if elem, ok := v.(map[string]interface{}); ok && fmt.Sprintf("%v", elem[scimKey(elem, attr)]) == value {
+			matching = append(matching, elem)
+		}
+	}
+
+	return matching
+}
+
+// Remove elements of the multi-valued attribute, having the attribute equal to the value
+func scimRemoveValues(values []interface{}, attr string, value string) []interface{} {
+	remaining := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if elem, ok := v.(map[string]interface{}); ok && fmt.Sprintf("%v", elem[scimKey(elem, attr)]) == value {
+			continue
+		}
+		remaining = append(remaining, v)
+	}
+
+	return remaining
+}
@@ -0,0 +1,190 @@
+package ldapsync
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap"
+)
+
+const scimTestToken = "secret"
+
+// Create the SCIM server on the in-memory directory, without any Uyuni behind it
+func newTestSCIMServer() (*SCIMServer, *httptest.Server) {
+	ls := new(LDAPSync)
+	ls.cr = &ConfigReader{config: NewConfig()}
+	ls.cr.config.Scim.Token = scimTestToken
+	ls.cr.config.Directory.Allusers = "ou=users,dc=example,dc=com"
+	ls.cr.config.Directory.Groupsbase = "ou=groups,dc=example,dc=com"
+	ls.cr.config.Directory.Logincase = LogincaseLower
+	ls.lc = NewMemoryDirectory()
+
+	srv := NewSCIMServer(ls)
+	return srv, httptest.NewServer(srv.Handler())
+}
+
+// Send the request with the bearer token and decode the JSON reply, if any
+func scimRequest(t *testing.T, ts *httptest.Server, method string, path string, body string, reply interface{}) int {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+scimPrefix+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+scimTestToken)
+	req.Header.Set("Content-Type", scimContentType)
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if reply != nil && res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(reply); err != nil {
+			t.Fatalf("%s %s: %s", method, path, err.Error())
+		}
+	}
+
+	return res.StatusCode
+}
+
+// Find the directory entry by its DN
+func scimTestEntry(srv *SCIMServer, dn string) *ldap.Entry {
+	for _, entry := range srv.dir.Entries() {
+		if EqualDN(entry.DN, dn) {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+func TestSCIMAuthentication(t *testing.T) {
+	_, ts := newTestSCIMServer()
+	defer ts.Close()
+
+	for _, auth := range []string{"", "Bearer wrong", "Basic " + scimTestToken} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+scimPrefix+"/Users", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected status %d, got %d", auth, http.StatusUnauthorized, res.StatusCode)
+		}
+	}
+}
+
+func TestSCIMUsersAndGroups(t *testing.T) {
+	srv, ts := newTestSCIMServer()
+	defer ts.Close()
+
+	user := new(SCIMUser)
+	status := scimRequest(t, ts, http.MethodPost, "/Users", `{"schemas":["`+SCIMSchemaUser+`"],"userName":"jdoe",
+		"name":{"givenName":"John","familyName":"Doe"},"emails":[{"value":"john@example.com","primary":true}]}`, user)
+	if status != http.StatusCreated || user.Id == "" {
+		t.Fatalf("Expected the user to be created, got status %d", status)
+	}
+
+	if status = scimRequest(t, ts, http.MethodPost, "/Users", `{"userName":"JDOE"}`, nil); status != http.StatusConflict {
+		t.Errorf("Expected a conflict of the user names, got status %d", status)
+	}
+
+	list := struct {
+		TotalResults int         `json:"totalResults"`
+		Resources    []*SCIMUser `json:"Resources"`
+	}{}
+	scimRequest(t, ts, http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq "jdoe"`), "", &list)
+	if list.TotalResults != 1 || list.Resources[0].Id != user.Id {
+		t.Errorf("Expected the user to be found by the filter, got %d results", list.TotalResults)
+	}
+
+	group := new(SCIMGroup)
+	status = scimRequest(t, ts, http.MethodPost, "/Groups", `{"displayName":"admins","members":[{"value":"`+user.Id+`"}]}`, group)
+	if status != http.StatusCreated {
+		t.Fatalf("Expected the group to be created, got status %d", status)
+	}
+
+	udn := "uid=jdoe,ou=users,dc=example,dc=com"
+	gdn := "cn=admins,ou=groups,dc=example,dc=com"
+	srv.publish()
+	entry := scimTestEntry(srv, udn)
+	if entry == nil || entry.GetAttributeValue("mail") != "john@example.com" || entry.GetAttributeValue("entryUUID") != user.Id {
+		t.Fatalf("Expected the user entry %s to be published", udn)
+	}
+	if members := scimTestEntry(srv, gdn).GetAttributeValues("member"); len(members) != 1 || !EqualDN(members[0], udn) {
+		t.Errorf("Expected the user to be a member of %s, got %v", gdn, members)
+	}
+
+	// Deactivated users stay in the directory, but not in the groups
+	updated := new(SCIMUser)
+	status = scimRequest(t, ts, http.MethodPatch, "/Users/"+user.Id, `{"schemas":["`+SCIMSchemaPatch+`"],
+		"Operations":[{"op":"replace","path":"active","value":"False"}]}`, updated)
+	if status != http.StatusOK || updated.IsActive() {
+		t.Fatalf("Expected the user to be deactivated, got status %d", status)
+	}
+	srv.publish()
+	if scimTestEntry(srv, udn) == nil || len(scimTestEntry(srv, gdn).GetAttributeValues("member")) != 0 {
+		t.Errorf("Expected the deactivated user to be published without the group membership")
+	}
+
+	if status = scimRequest(t, ts, http.MethodDelete, "/Users/"+user.Id, "", nil); status != http.StatusNoContent {
+		t.Fatalf("Expected the user to be deleted, got status %d", status)
+	}
+	if status = scimRequest(t, ts, http.MethodGet, "/Users/"+user.Id, "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected the deleted user to be gone, got status %d", status)
+	}
+	fetched := new(SCIMGroup)
+	scimRequest(t, ts, http.MethodGet, "/Groups/"+group.Id, "", fetched)
+	if len(fetched.Members) != 0 {
+		t.Errorf("Expected the deleted user to be removed from the group, got %d members", len(fetched.Members))
+	}
+}
+
+func TestSCIMDeletedUsersPendingUntilRemoved(t *testing.T) {
+	srv, ts := newTestSCIMServer()
+	defer ts.Close()
+
+	user := new(SCIMUser)
+	scimRequest(t, ts, http.MethodPost, "/Users", `{"userName":"jdoe"}`, user)
+	scimRequest(t, ts, http.MethodDelete, "/Users/"+user.Id, "", nil)
+
+	udn := "uid=jdoe,ou=users,dc=example,dc=com"
+	for _, tc := range []struct {
+		name    string
+		err     error
+		pending int
+	}{
+		{"failed removal", errors.New("Uyuni is down"), 1},
+		{"failed removal again", errors.New("Uyuni is down"), 1},
+		{"successful removal", nil, 0},
+	} {
+		published := srv.publish()
+		if len(published) != 1 || scimTestEntry(srv, udn) == nil {
+			t.Fatalf("%s: expected the deleted user to be published", tc.name)
+		}
+
+		report := NewSyncReport()
+		report.Add("JDoe", ActionDelete, tc.err)
+		if pending := srv.settle(published, report.Finish()); pending != tc.pending {
+			t.Errorf("%s: expected %d pending users, got %d", tc.name, tc.pending, pending)
+		}
+	}
+
+	if published := srv.publish(); len(published) != 0 || scimTestEntry(srv, udn) != nil {
+		t.Errorf("Expected the removed user not to be published anymore")
+	}
+}
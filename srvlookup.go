@@ -0,0 +1,122 @@
+package ldapsync
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SRVResolver looks up DNS SRV records.
+// The *net.Resolver satisfies it, and so can any local stub.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// LDAPServerLocator discovers directory servers via "_ldap._tcp.<domain>" SRV records
+type LDAPServerLocator struct {
+	domain   string
+	resolver SRVResolver
+	random   *rand.Rand
+	timeout  time.Duration
+}
+
+// NewLDAPServerLocator is a constructor for the LDAPServerLocator object
+func NewLDAPServerLocator(domain string) *LDAPServerLocator {
+	sl := new(LDAPServerLocator)
+	sl.domain = strings.TrimSuffix(domain, ".")
+	sl.resolver = net.DefaultResolver
+	sl.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	sl.timeout = 10 * time.Second
+
+	return sl
+}
+
+// SetResolver sets an alternative resolver, e.g. the one pointing to a local stub
+func (sl *LDAPServerLocator) SetResolver(resolver SRVResolver) *LDAPServerLocator {
+	sl.resolver = resolver
+	return sl
+}
+
+// SetRandom sets the random source for the weighted selection
+func (sl *LDAPServerLocator) SetRandom(random *rand.Rand) *LDAPServerLocator {
+	sl.random = random
+	return sl
+}
+
+// Servers returns "host:port" addresses of the discovered servers in the order
+// they should be tried: by priority first, then by weighted random selection.
+func (sl *LDAPServerLocator) Servers() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sl.timeout)
+	defer cancel()
+
+	_, records, err := sl.resolver.LookupSRV(ctx, "ldap", "tcp", sl.domain)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0)
+	for _, srv := range sl.order(records) {
+		// A single "." target means the service is decidedly not available (RFC 2782)
+		if srv.Target == "." {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprintf("%d", srv.Port)))
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("No LDAP servers found for domain '%s'", sl.domain)
+	}
+
+	return addrs, nil
+}
+
+// Order records according to RFC 2782: lowest priority first,
+// within the same priority pick randomly, proportionally to the weight.
+func (sl *LDAPServerLocator) order(records []*net.SRV) []*net.SRV {
+	byPriority := make(map[uint16][]*net.SRV)
+	priorities := make([]int, 0)
+	for _, srv := range records {
+		if _, ext := byPriority[srv.Priority]; !ext {
+			priorities = append(priorities, int(srv.Priority))
+		}
+		byPriority[srv.Priority] = append(byPriority[srv.Priority], srv)
+	}
+	sort.Ints(priorities)
+
+	ordered := make([]*net.SRV, 0, len(records))
+	for _, priority := range priorities {
+		group := byPriority[uint16(priority)]
+
+		// Zero-weight records should have a very small chance to be selected first
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Weight == 0 && group[j].Weight != 0 })
+		for len(group) > 0 {
+			total := 0
+			for _, srv := range group {
+				total += int(srv.Weight)
+			}
+
+			idx := 0
+			if total > 0 {
+				pick := sl.random.Intn(total + 1)
+				for i, srv := range group {
+					pick -= int(srv.Weight)
+					if pick <= 0 {
+						idx = i
+						break
+					}
+				}
+			} else {
+				idx = sl.random.Intn(len(group))
+			}
+
+			ordered = append(ordered, group[idx])
+			group = append(group[:idx], group[idx+1:]...)
+		}
+	}
+
+	return ordered
+}
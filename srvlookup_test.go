@@ -0,0 +1,136 @@
+package ldapsync
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// Stub resolver, answering with the fixed records
+type stubSRVResolver struct {
+	records []*net.SRV
+	err     error
+}
+
+func (sr *stubSRVResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records := make([]*net.SRV, 0, len(sr.records))
+	for _, srv := range sr.records {
+		record := *srv
+		records = append(records, &record)
+	}
+
+	return "_ldap._tcp." + name + ".", records, sr.err
+}
+
+func TestLDAPServerLocatorOrder(t *testing.T) {
+	const runs = 2000
+
+	for _, tc := range []struct {
+		name    string
+		records []*net.SRV
+		err     error
+		want    []string              // Exact order, if it does not depend on the weights
+		first   map[string][2]float64 // Bounds of the share of the runs, the server is tried first
+		fails   bool
+	}{
+		{
+			name: "priority ordering",
+			records: []*net.SRV{
+				{Target: "c.example.com.", Port: 389, Priority: 30, Weight: 100},
+				{Target: "a.example.com.", Port: 389, Priority: 10, Weight: 0},
+				{Target: "b.example.com.", Port: 636, Priority: 20, Weight: 50},
+			},
+			want: []string{"a.example.com:389", "b.example.com:636", "c.example.com:389"},
+		},
+		{
+			name: "unavailable target is skipped",
+			records: []*net.SRV{
+				{Target: ".", Port: 0, Priority: 0, Weight: 0},
+				{Target: "a.example.com.", Port: 389, Priority: 10, Weight: 10},
+			},
+			want: []string{"a.example.com:389"},
+		},
+		{
+			name: "weighted ordering",
+			records: []*net.SRV{
+				{Target: "light.example.com.", Port: 389, Priority: 10, Weight: 10},
+				{Target: "heavy.example.com.", Port: 389, Priority: 10, Weight: 90},
+				{Target: "backup.example.com.", Port: 389, Priority: 20, Weight: 100},
+			},
+			first: map[string][2]float64{
+				"heavy.example.com:389":  {0.85, 0.95},
+				"light.example.com:389":  {0.05, 0.15},
+				"backup.example.com:389": {0, 0},
+			},
+		},
+		{
+			name: "zero weight is rarely first",
+			records: []*net.SRV{
+				{Target: "zero.example.com.", Port: 389, Priority: 10, Weight: 0},
+				{Target: "some.example.com.", Port: 389, Priority: 10, Weight: 20},
+			},
+			first: map[string][2]float64{
+				"zero.example.com:389": {0, 0.1},
+				"some.example.com:389": {0.9, 1},
+			},
+		},
+		{
+			name: "all zero weights are equal",
+			records: []*net.SRV{
+				{Target: "a.example.com.", Port: 389, Priority: 10, Weight: 0},
+				{Target: "b.example.com.", Port: 389, Priority: 10, Weight: 0},
+			},
+			first: map[string][2]float64{
+				"a.example.com:389": {0.4, 0.6},
+				"b.example.com:389": {0.4, 0.6},
+			},
+		},
+		{
+			name:    "no available servers",
+			records: []*net.SRV{{Target: ".", Port: 0, Priority: 0, Weight: 0}},
+			fails:   true,
+		},
+		{
+			name:  "lookup failure",
+			err:   errors.New("no such host"),
+			fails: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sl := NewLDAPServerLocator("example.com.").
+				SetResolver(&stubSRVResolver{records: tc.records, err: tc.err}).
+				SetRandom(rand.New(rand.NewSource(1)))
+
+			counts := make(map[string]int)
+			for run := 0; run < runs; run++ {
+				addrs, err := sl.Servers()
+				if tc.fails {
+					if err == nil {
+						t.Fatalf("Expected an error, got %v", addrs)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tc.want != nil && !reflect.DeepEqual(addrs, tc.want) {
+					t.Fatalf("Expected %v, got %v", tc.want, addrs)
+				}
+				if len(addrs) != len(tc.records) && tc.first != nil {
+					t.Fatalf("Expected all %d servers, got %v", len(tc.records), addrs)
+				}
+				counts[addrs[0]]++
+			}
+
+			for addr, bounds := range tc.first {
+				share := float64(counts[addr]) / runs
+				if share < bounds[0] || share > bounds[1] {
+					t.Errorf("Expected %s to be first in %.2f-%.2f of the runs, got %.2f", addr, bounds[0], bounds[1], share)
+				}
+			}
+		})
+	}
+}
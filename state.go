@@ -0,0 +1,115 @@
+package ldapsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-yaml/yaml"
+)
+
+// SyncState is kept between the sync runs. It maps immutable IDs of the directory entries
+// (e.g. "entryUUID" or "objectGUID") to the Uyuni logins, so the renamed users are recognised.
+// It also lists the Uyuni logins, managed by the sync, i.e. created or adopted by it.
+type SyncState struct {
+	path    string
+	managed map[string]bool
+	Logins  map[string]string `yaml:"logins"`
+	Managed []string          `yaml:"managed"`
+}
+
+// NewSyncState is a constructor for the SyncState object
+func NewSyncState(path string) *SyncState {
+	state := new(SyncState)
+	state.path = path
+	state.Logins = make(map[string]string)
+	state.managed = make(map[string]bool)
+
+	return state
+}
+
+// Load the state from the file. A missing file is an empty state.
+func (state *SyncState) Load() error {
+	state.Logins = make(map[string]string)
+	state.Managed = nil
+	state.managed = make(map[string]bool)
+
+	buff, err := ioutil.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := yaml.Unmarshal(buff, state); err != nil {
+		return err
+	}
+	if state.Logins == nil {
+		state.Logins = make(map[string]string)
+	}
+	for _, login := range state.Managed {
+		state.managed[login] = true
+	}
+
+	return nil
+}
+
+// Save the state to the file. The file is replaced at once, so it is never half-written.
+func (state *SyncState) Save() error {
+	state.Managed = make([]string, 0, len(state.managed))
+	for login := range state.managed {
+		state.Managed = append(state.Managed, login)
+	}
+	sort.Strings(state.Managed)
+
+	buff, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(state.path), 0700); err != nil {
+		return err
+	}
+
+	tmp := state.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buff, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, state.path)
+}
+
+// Login returns the Uyuni login, last synchronised for the immutable ID
+func (state *SyncState) Login(id string) string {
+	return state.Logins[id]
+}
+
+// SetLogin records the Uyuni login of the immutable ID
+func (state *SyncState) SetLogin(id string, login string) *SyncState {
+	state.Logins[id] = login
+	return state
+}
+
+// Forget the immutable ID
+func (state *SyncState) Forget(id string) *SyncState {
+	delete(state.Logins, id)
+	return state
+}
+
+// IsManaged returns true if the Uyuni login is managed by the sync
+func (state *SyncState) IsManaged(login string) bool {
+	return state.managed[login]
+}
+
+// Manage marks the Uyuni login as managed by the sync
+func (state *SyncState) Manage(login string) *SyncState {
+	state.managed[login] = true
+	return state
+}
+
+// Release marks the Uyuni login as no longer managed by the sync
+func (state *SyncState) Release(login string) *SyncState {
+	delete(state.managed, login)
+	return state
+}
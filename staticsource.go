@@ -0,0 +1,199 @@
+package ldapsync
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap"
+	"github.com/go-yaml/yaml"
+)
+
+// StaticUser is a user record of the static identity source
+type StaticUser struct {
+	Uid        string
+	Name       string
+	Secondname string
+	Email      string
+	Groups     []string
+}
+
+// StaticDirectory is a directory, loaded from a CSV or YAML file with users and their group memberships.
+// Users are published under "allusers" DN, groups are either full DNs or names under "groupsbase" DN.
+type StaticDirectory struct {
+	*MemoryDirectory
+	path       string
+	format     string
+	usersBase  string
+	groupsBase string
+}
+
+// NewStaticDirectory is a constructor for the StaticDirectory object
+func NewStaticDirectory(path string, format string, usersBase string, groupsBase string) *StaticDirectory {
+	sd := new(StaticDirectory)
+	sd.MemoryDirectory = NewMemoryDirectory()
+	sd.path = path
+	sd.format = format
+	sd.usersBase = usersBase
+	sd.groupsBase = groupsBase
+
+	return sd
+}
+
+// Connect loads the file
+func (sd *StaticDirectory) Connect() {
+	if len(sd.Entries()) > 0 {
+		return
+	}
+
+	fh, err := os.Open(sd.path)
+	if err != nil {
+		Log.Fatal(err)
+	}
+	defer fh.Close()
+
+	var users []*StaticUser
+	if sd.format == SourceCSV {
+		users, err = ParseStaticCSV(fh)
+	} else {
+		users, err = ParseStaticYAML(fh)
+	}
+	if err != nil {
+		Log.Fatalf("Unable to load users from '%s': %s", sd.path, err.Error())
+	}
+
+	sd.Reset(sd.entries(users))
+	Log.Debugf("Loaded %d users from %s", len(users), sd.path)
+}
+
+// Get DN of the group, given either as a DN or as a name
+func (sd *StaticDirectory) groupDN(group string) string {
+	if strings.Contains(group, "=") {
+		return group
+	}
+	return fmt.Sprintf("cn=%s,%s", EscapeDNValue(group), sd.groupsBase)
+}
+
+// Create directory entries of the users and their groups
+func (sd *StaticDirectory) entries(users []*StaticUser) []*ldap.Entry {
+	entries := make([]*ldap.Entry, 0)
+	groups := make(map[string][]string)
+	for _, user := range users {
+		dn := fmt.Sprintf("uid=%s,%s", EscapeDNValue(user.Uid), sd.usersBase)
+		entries = append(entries, NewPersonEntry(dn, user.Uid, user.Name, user.Secondname, user.Email))
+		for _, group := range user.Groups {
+			group = sd.groupDN(strings.TrimSpace(group))
+			groups[group] = append(groups[group], dn)
+		}
+	}
+
+	dns := make([]string, 0, len(groups))
+	for dn := range groups {
+		dns = append(dns, dn)
+	}
+	sort.Strings(dns)
+
+	for _, dn := range dns {
+		cn := dn
+		if rdn, err := ldap.ParseDN(dn); err == nil && len(rdn.RDNs) > 0 && len(rdn.RDNs[0].Attributes) > 0 {
+			cn = rdn.RDNs[0].Attributes[0].Value
+		}
+		entries = append(entries, NewGroupEntry(dn, cn, groups[dn]))
+	}
+
+	return entries
+}
+
+// ParseStaticYAML reads users from YAML, having "users" list at the root
+func ParseStaticYAML(r io.Reader) ([]*StaticUser, error) {
+	buff, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Users []*StaticUser
+	}{}
+	if err = yaml.Unmarshal(buff, &data); err != nil {
+		return nil, err
+	}
+
+	for idx, user := range data.Users {
+		if user.Uid == "" {
+			return nil, fmt.Errorf("user #%d has no uid", idx+1)
+		}
+	}
+
+	return data.Users, checkStaticUids(data.Users)
+}
+
+// ParseStaticCSV reads users from CSV with the header, having "uid", "name", "secondname",
+// "email" and "groups" columns. Groups are separated by a semicolon.
+func ParseStaticCSV(r io.Reader) ([]*StaticUser, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("header is missing")
+	}
+
+	columns := make(map[string]int)
+	for idx, column := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(column))] = idx
+	}
+	if _, ext := columns["uid"]; !ext {
+		return nil, fmt.Errorf("column 'uid' is missing")
+	}
+
+	get := func(record []string, column string) string {
+		if idx, ext := columns[column]; ext && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	users := make([]*StaticUser, 0)
+	for idx, record := range records[1:] {
+		user := &StaticUser{
+			Uid:        get(record, "uid"),
+			Name:       get(record, "name"),
+			Secondname: get(record, "secondname"),
+			Email:      get(record, "email"),
+		}
+		if user.Uid == "" {
+			return nil, fmt.Errorf("record #%d has no uid", idx+1)
+		}
+		for _, group := range strings.Split(get(record, "groups"), ";") {
+			if group = strings.TrimSpace(group); group != "" {
+				user.Groups = append(user.Groups, group)
+			}
+		}
+		users = append(users, user)
+	}
+
+	return users, checkStaticUids(users)
+}
+
+// Check every uid is listed only once. Their entries would have the same DN otherwise.
+func checkStaticUids(users []*StaticUser) error {
+	seen := make(map[string]bool)
+	for _, user := range users {
+		key := strings.ToLower(user.Uid)
+		if seen[key] {
+			return fmt.Errorf("user '%s' is listed more than once", user.Uid)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
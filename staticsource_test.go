@@ -0,0 +1,203 @@
+package ldapsync
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap"
+)
+
+func TestParseStaticCSV(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		csv   string
+		want  []*StaticUser
+		fails bool
+	}{
+		{
+			name: "header in any order and case",
+			csv: `Email, UID, groups
+john@example.com, jdoe, admins
+`,
+			want: []*StaticUser{{Uid: "jdoe", Email: "john@example.com", Groups: []string{"admins"}}},
+		},
+		{
+			name: "group separator and comments",
+			csv: `uid,name,secondname,email,groups
+# Administrators
+jdoe,John,Doe,john@example.com," admins ;cn=ops,ou=teams,dc=example,dc=com;;"
+jroe,Jane,Roe,,
+`,
+			want: []*StaticUser{
+				{Uid: "jdoe", Name: "John", Secondname: "Doe", Email: "john@example.com",
+					Groups: []string{"admins", "cn=ops,ou=teams,dc=example,dc=com"}},
+				{Uid: "jroe", Name: "Jane", Secondname: "Roe"},
+			},
+		},
+		{
+			name: "missing trailing columns",
+			csv: `uid,name,secondname,email,groups
+jdoe,John
+`,
+			want: []*StaticUser{{Uid: "jdoe", Name: "John"}},
+		},
+		{
+			name:  "missing uid column",
+			csv:   "name,email\nJohn,john@example.com\n",
+			fails: true,
+		},
+		{
+			name:  "empty uid",
+			csv:   "uid,name\n,John\n",
+			fails: true,
+		},
+		{
+			name:  "missing header",
+			csv:   "",
+			fails: true,
+		},
+		{
+			name:  "duplicate uids",
+			csv:   "uid,name\njdoe,John\nJDoe,Johnny\n",
+			fails: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			users, err := ParseStaticCSV(strings.NewReader(tc.csv))
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("Expected an error, got %d users", len(users))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(users, tc.want) {
+				t.Errorf("Expected %s, got %s", staticTestUsers(tc.want), staticTestUsers(users))
+			}
+		})
+	}
+}
+
+func TestParseStaticYAML(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		yaml  string
+		want  []*StaticUser
+		fails bool
+	}{
+		{
+			name: "users with groups",
+			yaml: `users:
+  - uid: jdoe
+    name: John
+    secondname: Doe
+    email: john@example.com
+    groups: [admins, "cn=ops,ou=teams,dc=example,dc=com"]
+  - uid: jroe
+`,
+			want: []*StaticUser{
+				{Uid: "jdoe", Name: "John", Secondname: "Doe", Email: "john@example.com",
+					Groups: []string{"admins", "cn=ops,ou=teams,dc=example,dc=com"}},
+				{Uid: "jroe"},
+			},
+		},
+		{
+			name:  "missing uid",
+			yaml:  "users:\n  - name: John\n",
+			fails: true,
+		},
+		{
+			name:  "duplicate uids",
+			yaml:  "users:\n  - uid: jdoe\n  - uid: JDOE\n",
+			fails: true,
+		},
+		{
+			name:  "invalid YAML",
+			yaml:  "users: [",
+			fails: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			users, err := ParseStaticYAML(strings.NewReader(tc.yaml))
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("Expected an error, got %d users", len(users))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(users, tc.want) {
+				t.Errorf("Expected %s, got %s", staticTestUsers(tc.want), staticTestUsers(users))
+			}
+		})
+	}
+}
+
+// Published entries have to be found by the same searches the sync issues on a live server
+func TestStaticDirectoryEntries(t *testing.T) {
+	users, err := ParseStaticCSV(strings.NewReader(`uid,name,secondname,email,groups
+jdoe,John,Doe,john@example.com,"admins;cn=ops,ou=teams,dc=example,dc=com"
+jroe,Jane,Roe,,admins
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := NewStaticDirectory("", SourceCSV, "ou=users,dc=example,dc=com", "ou=groups,dc=example,dc=com")
+	sd.Reset(sd.entries(users))
+
+	for _, tc := range []struct {
+		name   string
+		base   string
+		filter string
+		want   []string
+	}{
+		{"users by the default filter", "ou=users,dc=example,dc=com", DefaultUserFilter,
+			[]string{"uid=jdoe,ou=users,dc=example,dc=com", "uid=jroe,ou=users,dc=example,dc=com"}},
+		{"user by the login", "ou=users,dc=example,dc=com", "(&" + DefaultUserFilter + "(uid=JDOE))",
+			[]string{"uid=jdoe,ou=users,dc=example,dc=com"}},
+		{"group by the name", "cn=admins,ou=groups,dc=example,dc=com", "(|(objectClass=groupOfNames)(objectClass=group))",
+			[]string{"cn=admins,ou=groups,dc=example,dc=com"}},
+		{"group by the DN", "ou=teams,dc=example,dc=com", "(|(objectClass=groupOfNames)(objectClass=group))",
+			[]string{"cn=ops,ou=teams,dc=example,dc=com"}},
+		{"group membership", "ou=groups,dc=example,dc=com", "(member=uid=jroe,ou=users,dc=example,dc=com)",
+			[]string{"cn=admins,ou=groups,dc=example,dc=com"}},
+		{"no users are groups", "ou=users,dc=example,dc=com", "(|(objectClass=groupOfNames)(objectClass=group))", []string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := ldap.NewSearchRequest(tc.base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, tc.filter, []string{}, nil)
+			got := make([]string, 0)
+			for _, entry := range sd.Search(req).Entries {
+				got = append(got, entry.DN)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	req := ldap.NewSearchRequest("uid=jdoe,ou=users,dc=example,dc=com", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)", []string{}, nil)
+	entry := sd.Search(req).Entries[0]
+	for attr, value := range map[string]string{"uid": "jdoe", "givenName": "John", "sn": "Doe", "cn": "John Doe", "mail": "john@example.com"} {
+		if entry.GetAttributeValue(attr) != value {
+			t.Errorf("Expected '%s' of the user to be '%s', got '%s'", attr, value, entry.GetAttributeValue(attr))
+		}
+	}
+}
+
+// Format the users for the error messages
+func staticTestUsers(users []*StaticUser) string {
+	out := make([]string, 0, len(users))
+	for _, user := range users {
+		out = append(out, strings.Join([]string{user.Uid, user.Name, user.Secondname, user.Email, strings.Join(user.Groups, ";")}, "|"))
+	}
+
+	return "[" + strings.Join(out, ", ") + "]"
+}
@@ -0,0 +1,85 @@
+package ldapsync
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thoas/go-funk"
+)
+
+// SourceTemporary is the source of the grants by the time-bounded mappings
+const SourceTemporary = "temporary"
+
+// TemporaryMapping grants roles to the members of the group or role occupants
+// only within the time window. Either of the bounds can be omitted.
+type TemporaryMapping struct {
+	Dn         string     `yaml:"dn"`
+	Roles      []string   `yaml:"roles"`
+	ValidFrom  *time.Time `yaml:"valid_from"`
+	ValidUntil *time.Time `yaml:"valid_until"`
+}
+
+// IsActive returns true if the time is within the window of the mapping
+func (tm *TemporaryMapping) IsActive(now time.Time) bool {
+	return (tm.ValidFrom == nil || !now.Before(*tm.ValidFrom)) && (tm.ValidUntil == nil || now.Before(*tm.ValidUntil))
+}
+
+// RoleExpiration describes a temporary role, the user is going to lose
+type RoleExpiration struct {
+	Uid        string    `json:"uid" yaml:"uid"`
+	Role       string    `json:"role" yaml:"role"`
+	Mapping    string    `json:"mapping" yaml:"mapping"`
+	ValidUntil time.Time `json:"valid_until" yaml:"valid_until"`
+}
+
+// Merge roles of the temporary mappings, which are active now
+func (sync *LDAPSync) mergeTemporaryRoles(user *UyuniUser) {
+	now := time.Now()
+	for _, tm := range sync.cr.Config().Directory.Temporary {
+		if !tm.IsActive(now) {
+			continue
+		}
+
+		roles := sync.grantableRoles(tm.Roles)
+		for _, searchConfig := range sync.roleConfigs {
+			for _, path := range sync.membershipPaths(tm.Dn, user, searchConfig) {
+				for _, role := range roles {
+					user.grants = append(user.grants, &RoleGrant{Role: role, Source: SourceTemporary, Mapping: tm.Dn, Path: path,
+						ValidFrom: tm.ValidFrom, ValidUntil: tm.ValidUntil})
+				}
+				user.AddRoles(roles...)
+			}
+		}
+	}
+}
+
+// Expirations returns temporary roles of the LDAP users, sorted by their expiration.
+// A role is listed only if no other grant keeps it after the expiration, not even as implied by "org_admin".
+func (sync *LDAPSync) Expirations() []*RoleExpiration {
+	expirations := make([]*RoleExpiration, 0)
+	for _, user := range sync.ldapusers {
+		seen := make(map[string]bool)
+		for _, grant := range user.GetGrants() {
+			if grant.ValidUntil == nil || seen[grant.Role+"\n"+grant.Mapping] {
+				continue
+			}
+			seen[grant.Role+"\n"+grant.Mapping] = true
+
+			kept := NewUyuniUser().SetImpliedRoles(user.implied)
+			for _, other := range user.GetGrants() {
+				if other.ValidUntil == nil || other.ValidUntil.After(*grant.ValidUntil) {
+					kept.AddRoles(other.Role)
+				}
+			}
+			if !funk.ContainsString(kept.GetRoles(), strings.ToLower(grant.Role)) {
+				expirations = append(expirations, &RoleExpiration{Uid: user.Uid, Role: grant.Role, Mapping: grant.Mapping,
+					ValidUntil: *grant.ValidUntil})
+			}
+		}
+	}
+
+	sort.SliceStable(expirations, func(i, j int) bool { return expirations[i].ValidUntil.Before(expirations[j].ValidUntil) })
+
+	return expirations
+}
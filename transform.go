@@ -0,0 +1,176 @@
+package ldapsync
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Uyuni user fields, the transforms are applied to
+const (
+	FieldUid       = "uid"
+	FieldEmail     = "email"
+	FieldFirstname = "firstname"
+	FieldLastname  = "lastname"
+)
+
+// Transform changes the values of an attribute
+type Transform func(values []string) []string
+
+// TransformChain is a sequence of transforms, applied one after another
+type TransformChain []Transform
+
+// ParseTransformChain parses transform specifications, like "lower", "stripdomain"
+// or "s/pattern/replacement/" into a chain.
+func ParseTransformChain(specs []string) (TransformChain, error) {
+	chain := make(TransformChain, 0, len(specs))
+	for _, spec := range specs {
+		transform, err := ParseTransform(spec)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, transform)
+	}
+
+	return chain, nil
+}
+
+// Apply the chain to the values and return the first resulting value
+func (chain TransformChain) Apply(values []string) string {
+	for _, transform := range chain {
+		values = transform(values)
+	}
+
+	if len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+// ParseTransform parses a single transform specification:
+//
+//	lower, upper      change the case
+//	trim              remove leading and trailing whitespace
+//	normalize         compose Unicode (NFC), collapse whitespace and remove invisible formatting characters
+//	stripdomain       remove "@domain" suffix or "DOMAIN\" prefix
+//	primary           pick the primary address of "SMTP:"-prefixed values, or the first value
+//	s/pattern/repl/   replace regular expression matches, "$1" refers to a group
+func ParseTransform(spec string) (Transform, error) {
+	switch strings.TrimSpace(spec) {
+	case "lower":
+		return eachValue(strings.ToLower), nil
+	case "upper":
+		return eachValue(strings.ToUpper), nil
+	case "trim":
+		return eachValue(strings.TrimSpace), nil
+	case "normalize":
+		return eachValue(normalizeValue), nil
+	case "stripdomain":
+		return eachValue(stripDomain), nil
+	case "primary":
+		return primaryValue, nil
+	}
+
+	if len(spec) > 1 && spec[0] == 's' && strings.ContainsAny(spec[1:2], "/|#,:!@%") {
+		return parseSubstitution(spec)
+	}
+
+	return nil, fmt.Errorf("unknown transform '%s'", spec)
+}
+
+// Apply the function to each value
+func eachValue(f func(string) string) Transform {
+	return func(values []string) []string {
+		out := make([]string, len(values))
+		for idx, value := range values {
+			out[idx] = f(value)
+		}
+		return out
+	}
+}
+
+// Compose Unicode to NFC, so decomposed and precomposed characters compare equal,
+// collapse whitespace and remove format characters, like zero-width spaces
+func normalizeValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, value)
+
+	return norm.NFC.String(strings.Join(strings.Fields(value), " "))
+}
+
+// Remove the domain of "user@domain" or "DOMAIN\user"
+func stripDomain(value string) string {
+	if idx := strings.LastIndex(value, "@"); idx > 0 {
+		value = value[:idx]
+	}
+	if idx := strings.Index(value, "\\"); idx >= 0 {
+		value = value[idx+1:]
+	}
+
+	return value
+}
+
+// Pick the primary address. In "proxyAddresses" it is prefixed with upper-case "SMTP:",
+// secondary ones with lower-case "smtp:". Other values are taken in their order.
+func primaryValue(values []string) []string {
+	primary := ""
+	for _, value := range values {
+		if strings.HasPrefix(value, "SMTP:") {
+			primary = value
+			break
+		}
+		if primary == "" && value != "" {
+			primary = value
+		}
+	}
+
+	if primary == "" {
+		return nil
+	}
+
+	if idx := strings.Index(primary, ":"); idx > 0 && strings.EqualFold(primary[:idx], "smtp") {
+		primary = primary[idx+1:]
+	}
+
+	return []string{primary}
+}
+
+// Parse "s/pattern/replacement/", where any character after "s" is the delimiter
+func parseSubstitution(spec string) (Transform, error) {
+	delim := spec[1:2]
+	parts := make([]string, 0, 3)
+	var part strings.Builder
+	for idx := 2; idx < len(spec); idx++ {
+		switch {
+		case spec[idx] == '\\' && idx+1 < len(spec) && spec[idx+1:idx+2] == delim:
+			part.WriteString(delim)
+			idx++
+		case spec[idx:idx+1] == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(spec[idx])
+		}
+	}
+
+	if len(parts) != 2 || part.Len() > 0 {
+		return nil, fmt.Errorf("invalid substitution '%s', expected s%spattern%sreplacement%s", spec, delim, delim, delim)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern of the substitution '%s': %s", spec, err.Error())
+	}
+
+	return eachValue(func(value string) string {
+		return re.ReplaceAllString(value, parts[1])
+	}), nil
+}
@@ -1,23 +1,106 @@
 package ldapsync
 
 import (
+	"sort"
+	"strings"
+
+	"github.com/go-ldap/ldap"
 	"github.com/thoas/go-funk"
 )
 
-// CompareRoles of two users
-func CompareRoles(a *UyuniUser, b *UyuniUser) bool {
-	ra := a.GetRoles()
-	rb := b.GetRoles()
+// DiffRoles returns roles of the user b that are not in the user a (added)
+// and roles of the user a that are not in the user b (removed)
+func DiffRoles(a *UyuniUser, b *UyuniUser) ([]string, []string) {
+	added := make([]string, 0)
+	removed := make([]string, 0)
+
+	for _, r := range b.GetRoles() {
+		if !funk.ContainsString(a.GetRoles(), r) {
+			added = append(added, r)
+		}
+	}
+
+	for _, r := range a.GetRoles() {
+		if !funk.ContainsString(b.GetRoles(), r) {
+			removed = append(removed, r)
+		}
+	}
+
+	return added, removed
+}
+
+// ParentDN returns the DN without its first RDN, e.g. "dc=example,dc=com" for "ou=Users,dc=example,dc=com"
+func ParentDN(dn string) string {
+	for idx := 0; idx < len(dn); idx++ {
+		switch dn[idx] {
+		case '\\':
+			idx++
+		case ',':
+			return strings.TrimSpace(dn[idx+1:])
+		}
+	}
+
+	return ""
+}
+
+// EscapeDNValue escapes a value to be used in RDN (RFC 4514)
+func EscapeDNValue(value string) string {
+	var buff strings.Builder
+	for idx, c := range value {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", c),
+			idx == 0 && (c == ' ' || c == '#'),
+			idx == len(value)-1 && c == ' ':
+			buff.WriteRune('\\')
+		}
+		buff.WriteRune(c)
+	}
+
+	return buff.String()
+}
+
+// DNDepthUnder returns the amount of RDNs of the base, if the DN is the base or falls under it.
+// Otherwise returns -1. DNs are compared case-insensitively.
+func DNDepthUnder(dn string, base string) int {
+	parsedDn, err := ldap.ParseDN(strings.ToLower(dn))
+	if err != nil {
+		return -1
+	}
+	parsedBase, err := ldap.ParseDN(strings.ToLower(base))
+	if err != nil {
+		return -1
+	}
+
+	if parsedBase.Equal(parsedDn) || parsedBase.AncestorOf(parsedDn) {
+		return len(parsedBase.RDNs)
+	}
 
-	if len(ra) != len(rb) {
-		return false
+	return -1
+}
+
+// CanonicalDN returns the DN in a canonical form (RFC 4514) for comparison: attribute types
+// and values are lower-cased, insignificant spaces are removed, values are escaped uniformly
+// and attributes of multi-valued RDNs are sorted. An unparseable DN is only lower-cased.
+func CanonicalDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
 	}
 
-	for _, r := range ra {
-		if !funk.Contains(rb, r) {
-			return false
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, attr := range rdn.Attributes {
+			attrs = append(attrs, strings.ToLower(strings.TrimSpace(attr.Type))+"="+EscapeDNValue(strings.ToLower(attr.Value)))
 		}
+		sort.Strings(attrs)
+		rdns = append(rdns, strings.Join(attrs, "+"))
 	}
 
-	return true
+	return strings.Join(rdns, ",")
+}
+
+// EqualDN compares two DNs in their canonical form
+func EqualDN(a string, b string) bool {
+	return CanonicalDN(a) == CanonicalDN(b)
 }
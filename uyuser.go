@@ -2,9 +2,66 @@ package ldapsync
 
 import (
 	"strings"
+	"time"
 )
 
+// FieldChange describes an account field that has been changed in LDAP
+type FieldChange struct {
+	Field  string `json:"field" yaml:"field"`
+	Before string `json:"before" yaml:"before"`
+	After  string `json:"after" yaml:"after"`
+}
+
+// RoleGrant describes a configured mapping that contributed a role to the user.
+// Path lists DNs from the mapping down to the entry, which has the user as a member.
+type RoleGrant struct {
+	Role       string     `json:"role" yaml:"role"`
+	Source     string     `json:"source" yaml:"source"`
+	Mapping    string     `json:"mapping" yaml:"mapping"`
+	Path       []string   `json:"path" yaml:"path"`
+	ValidFrom  *time.Time `json:"valid_from,omitempty" yaml:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty" yaml:"valid_until,omitempty"`
+}
+
+// ServerRoles are scoped to the whole server, not to the organisation.
+// They are never implied by "org_admin" and are granted only by explicit mapping.
+var ServerRoles = []string{"satellite_admin"}
+
+// Roles, implied by "org_admin", unless the roles, assignable on the server, are known
+var defaultOrgAdminImpliedRoles = []string{
+	"org_admin",
+	"channel_admin",
+	"config_admin",
+	"system_group_admin",
+	"activation_key_admin",
+	"image_admin",
+}
+
+// OrgAdminImpliedRoles returns roles, implied by "org_admin", out of the assignable ones.
+// Server-wide roles are omitted.
+func OrgAdminImpliedRoles(assignable []string) []string {
+	implied := []string{"org_admin"}
+	for _, role := range assignable {
+		role = strings.ToLower(role)
+		if role != "org_admin" && !IsServerRole(role) {
+			implied = append(implied, role)
+		}
+	}
+	return implied
+}
+
+// IsServerRole returns true if the role is scoped to the whole server
+func IsServerRole(role string) bool {
+	for _, r := range ServerRoles {
+		if r == strings.ToLower(role) {
+			return true
+		}
+	}
+	return false
+}
+
 type UyuniUser struct {
+	Id             string
 	Dn             string
 	Uid            string
 	Name           string
@@ -17,6 +74,13 @@ type UyuniUser struct {
 	outdated       bool
 	roleschanged   bool
 	accountchanged bool
+	changes        []*FieldChange
+	rolesadded     []string
+	rolesremoved   []string
+	grants         []*RoleGrant
+	denials        []*RoleDenial
+	override       *AppliedOverride
+	implied        []string
 
 	POSSIBLE_ROLES [7]string
 }
@@ -39,18 +103,36 @@ func NewUyuniUser() *UyuniUser {
 	return uu
 }
 
+// SetImpliedRoles sets roles, implied by "org_admin" for this user. Defaults are used, if not set.
+func (u *UyuniUser) SetImpliedRoles(roles []string) *UyuniUser {
+	u.implied = roles
+	return u
+}
+
+// Get roles, implied by "org_admin"
+func (u *UyuniUser) impliedRoles() []string {
+	if u.implied == nil {
+		return defaultOrgAdminImpliedRoles
+	}
+	return u.implied
+}
+
 // AddRole allows add distinct roles to the user
 func (u *UyuniUser) AddRoles(newRoles ...string) {
 	for _, role := range newRoles {
 		role = strings.ToLower(role)
 
-		// Org admin gets everything
+		// Org admin gets everything within the organisation, server-wide roles are kept
 		if role == "org_admin" {
-			u.FlushRoles()
-			for _, sr := range u.POSSIBLE_ROLES {
-				u.roles = append(u.roles, sr)
+			server := make([]string, 0)
+			for _, userRole := range u.roles {
+				if IsServerRole(userRole) {
+					server = append(server, userRole)
+				}
 			}
-			return
+			u.FlushRoles()
+			u.roles = append(append(u.roles, u.impliedRoles()...), server...)
+			continue
 		}
 
 		for _, userRole := range u.roles {
@@ -109,9 +191,40 @@ func (u *UyuniUser) IsRolesChanged() bool {
 	return u.roleschanged
 }
 
+// GetChanges returns account fields that has been changed
+func (u *UyuniUser) GetChanges() []*FieldChange {
+	return u.changes
+}
+
+// GetAddedRoles returns roles that are going to be added to the user
+func (u *UyuniUser) GetAddedRoles() []string {
+	return u.rolesadded
+}
+
+// GetRemovedRoles returns roles that are going to be removed from the user
+func (u *UyuniUser) GetRemovedRoles() []string {
+	return u.rolesremoved
+}
+
+// GetGrants returns mappings that contributed roles to the user
+func (u *UyuniUser) GetGrants() []*RoleGrant {
+	return u.grants
+}
+
+// GetDenials returns deny mappings, matching the user
+func (u *UyuniUser) GetDenials() []*RoleDenial {
+	return u.denials
+}
+
+// GetOverride returns the local override, applied to the user
+func (u *UyuniUser) GetOverride() *AppliedOverride {
+	return u.override
+}
+
 // Clone creates a new user instance with the same data
 func (u *UyuniUser) Clone() *UyuniUser {
-	user := NewUyuniUser()
+	user := NewUyuniUser().SetImpliedRoles(u.implied)
+	user.Id = u.Id
 	user.Dn = u.Dn
 	user.Uid = u.Uid
 	user.Email = u.Email
@@ -123,7 +236,23 @@ func (u *UyuniUser) Clone() *UyuniUser {
 	user.removed = u.removed
 	user.accountchanged = u.accountchanged
 	user.roleschanged = u.roleschanged
+	user.changes = append(user.changes, u.changes...)
+	user.rolesadded = append(user.rolesadded, u.rolesadded...)
+	user.rolesremoved = append(user.rolesremoved, u.rolesremoved...)
+	user.grants = append(user.grants, u.grants...)
+	user.denials = append(user.denials, u.denials...)
+	user.override = u.override
 	user.AddRoles(u.GetRoles()...)
 
 	return user
 }
+
+// IsPossibleRole returns true if the role is known to Uyuni
+func IsPossibleRole(role string) bool {
+	for _, r := range NewUyuniUser().POSSIBLE_ROLES {
+		if r == strings.ToLower(role) {
+			return true
+		}
+	}
+	return false
+}
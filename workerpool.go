@@ -0,0 +1,52 @@
+package ldapsync
+
+import (
+	"sync"
+)
+
+// WorkerPool runs jobs concurrently on a bounded number of workers
+type WorkerPool struct {
+	workers int
+}
+
+// NewWorkerPool is a constructor for the WorkerPool object
+func NewWorkerPool(workers int) *WorkerPool {
+	wp := new(WorkerPool)
+	wp.workers = workers
+	if wp.workers < 1 {
+		wp.workers = 1
+	}
+
+	return wp
+}
+
+// Run calls the job for every index from 0 to n-1 and waits until all of them are done.
+// Errors are returned in the same order as the indices, regardless of the completion order.
+func (wp *WorkerPool) Run(n int, job func(idx int) error) []error {
+	errs := make([]error, n)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	workers := wp.workers
+	if workers > n {
+		workers = n
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				errs[idx] = job(idx)
+			}
+		}()
+	}
+
+	for idx := 0; idx < n; idx++ {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errs
+}